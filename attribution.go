@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Types of MessageOrigin (forward_origin)
+const (
+	originUser       = "user"
+	originHiddenUser = "hidden_user"
+	originChat       = "chat"
+	originChannel    = "channel"
+)
+
+// Placeholders of attribution templates
+const (
+	placeholderMention = "{mention}" // The sender as a mention that notifies them
+	placeholderName    = "{name}"    // The sender's plain display name
+)
+
+// defaultAttributionTemplate stands for the translated default template ("{mention} said:") in chats
+// that didn't set their own. An empty template disables attribution.
+const defaultAttributionTemplate = "default"
+
+// maxAttributionTemplateLength limits templates to a short prefix
+const maxAttributionTemplateLength = 64
+
+// renderAttribution fills in the placeholders of template for the sender of m. For forwarded
+// messages the forward origin follows the first placeholder, e.g. "@bob (forwarded from News) said:",
+// or the whole text if the template has none.
+func renderAttribution(template string, m *tele.Message, lang string) (text string, entities tele.Entities) {
+	if template == defaultAttributionTemplate {
+		template = tr(lang, "attribution.default")
+	}
+	var forwarded string
+	var forwardedEntities tele.Entities
+	if m.Origin != nil {
+		forwarded, forwardedEntities = forwardAttribution(m.Origin, lang, "attribution.forwarded_from")
+	}
+
+	for template != "" {
+		idx := strings.IndexByte(template, '{')
+		if idx < 0 {
+			text += template
+			break
+		}
+		text, template = text+template[:idx], template[idx:]
+
+		var sender string
+		var senderEntity *tele.MessageEntity
+		switch {
+		case strings.HasPrefix(template, placeholderMention):
+			sender, senderEntity = senderMention(m, lang)
+			template = template[len(placeholderMention):]
+		case strings.HasPrefix(template, placeholderName):
+			sender = senderName(m, lang)
+			template = template[len(placeholderName):]
+		default: // Not a placeholder, keep the brace
+			text, template = text+"{", template[1:]
+			continue
+		}
+
+		if senderEntity != nil {
+			senderEntity.Offset, senderEntity.Length = utf16Len(text), utf16Len(sender)
+			entities = append(entities, *senderEntity)
+		}
+		text += sender
+		if forwarded != "" {
+			text, entities = appendForwarded(text, entities, forwarded, forwardedEntities)
+			forwarded = "" // The origin is named once
+		}
+	}
+	if forwarded != "" {
+		text, entities = appendForwarded(text, entities, forwarded, forwardedEntities)
+	}
+	return text, entities
+}
+
+// appendForwarded adds the forward origin in parentheses to an attribution
+func appendForwarded(text string, entities tele.Entities, forwarded string, forwardedEntities tele.Entities) (string, tele.Entities) {
+	if text != "" {
+		text += " "
+	}
+	text += "("
+	entities = append(entities, remapEntities(forwardedEntities, []textEdit{{start: 0, end: 0, newLength: utf16Len(text)}})...)
+	return text + forwarded + ")", entities
+}
+
+// senderName names who posted m. Messages sent on behalf of a chat (anonymous admins,
+// "send as channel", automatic forwards) are attributed to that chat, never to the user
+// behind it or to the GroupAnonymousBot/Channel_Bot placeholder users.
+func senderName(m *tele.Message, lang string) string {
+	if chat := m.SenderChat; chat != nil {
+		if chat.ID == m.Chat.ID { // Anonymous admin, the signature is their public custom title
+			return withSignature(chat.Title, m.Signature)
+		}
+		return chat.Title
+	}
+	if m.Sender == nil {
+		return tr(lang, "attribution.someone")
+	}
+	return strings.TrimSpace(m.Sender.FirstName + " " + m.Sender.LastName)
+}
+
+// senderMention returns the sender of m as a mention. Users with a username get a regular
+// @mention, all others a text_mention of their name. Public chats link to themselves.
+// The entity's offset and length are left for the caller to fill in.
+func senderMention(m *tele.Message, lang string) (string, *tele.MessageEntity) {
+	if m.SenderChat != nil {
+		if link := chatLink(m.SenderChat, 0); link != "" {
+			return senderName(m, lang), &tele.MessageEntity{Type: tele.EntityTextLink, URL: link}
+		}
+		return senderName(m, lang), nil
+	}
+	if m.Sender == nil {
+		return senderName(m, lang), nil
+	}
+	if m.Sender.Username != "" {
+		return "@" + m.Sender.Username, &tele.MessageEntity{Type: tele.EntityMention}
+	}
+	return senderName(m, lang), &tele.MessageEntity{Type: tele.EntityTMention, User: m.Sender}
+}
+
+// forwardAttribution builds the "forwarded from <origin>" part of a repost from the catalog entry key,
+// with the origin linking back to the original post where Telegram allows it.
+func forwardAttribution(origin *tele.MessageOrigin, lang, key string) (text string, entities tele.Entities) {
+	name, link := forwardOrigin(origin, lang)
+	before, after, _ := strings.Cut(tr(lang, key), "{origin}")
+	if link != "" {
+		entities = tele.Entities{{Type: tele.EntityTextLink, Offset: utf16Len(before), Length: utf16Len(name), URL: link}}
+	}
+	return before + name + after, entities
+}
+
+func forwardOrigin(origin *tele.MessageOrigin, lang string) (name, link string) {
+	switch origin.Type {
+	case originUser:
+		if origin.Sender != nil {
+			if origin.Sender.Username != "" {
+				return "@" + origin.Sender.Username, "https://t.me/" + origin.Sender.Username
+			}
+			return strings.TrimSpace(origin.Sender.FirstName + " " + origin.Sender.LastName), ""
+		}
+	case originHiddenUser:
+		return origin.SenderUsername, "" // The user doesn't allow linking to their account
+	case originChat:
+		if origin.SenderChat != nil {
+			return withSignature(origin.SenderChat.Title, origin.Signature), chatLink(origin.SenderChat, 0)
+		}
+	case originChannel:
+		if origin.Chat != nil {
+			return withSignature(origin.Chat.Title, origin.Signature), chatLink(origin.Chat, origin.MessageID)
+		}
+	}
+	return tr(lang, "attribution.unknown_origin"), ""
+}
+
+func withSignature(title, signature string) string {
+	if signature == "" {
+		return title
+	}
+	return fmt.Sprintf("%s (%s)", title, signature)
+}
+
+// chatLink links to a public chat by username, or to a post in a private supergroup/channel
+// (which only works for its members). messageID 0 links to the chat itself.
+func chatLink(chat *tele.Chat, messageID int) string {
+	if chat.Username != "" {
+		if messageID == 0 {
+			return "https://t.me/" + chat.Username
+		}
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, messageID)
+	}
+	internalID, isSupergroup := strings.CutPrefix(strconv.FormatInt(chat.ID, 10), "-100")
+	if !isSupergroup || messageID == 0 {
+		return ""
+	}
+	return fmt.Sprintf("https://t.me/c/%s/%d", internalID, messageID)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+)
+
+// Kinds of linkChange
+const (
+	changeMobileHost   = "mobile_host"
+	changeHTTPSUpgrade = "https_upgrade"
+	changeExpansion    = "expansion"
+	changeTikTokAlbum  = "tiktok_album"
+	changeQueryParam   = "query_param"
+	changePathSegment  = "path_segment"
+	changeMatrixParam  = "matrix_param"
+	changeFragment     = "fragment"
+	changeFrontend     = "frontend"
+)
+
+// linkChange is one step sanitizeLink took on a link
+type linkChange struct {
+	Kind  string   // One of the change* constants
+	Rule  string   // Rule that matched, e.g. "URLRules:utm_" or "DomainRules[amazon]:tag"
+	Key   string   // Removed parameter, path segment or fragment part
+	Value string   // Value of a removed parameter
+	From  string   // Host or scheme before a rewrite
+	To    string   // Host or scheme after a rewrite
+	Chain []string // Redirects followed while expanding a short link, starting with the short link
+}
+
+// linkReport describes what happened to one link of a message
+type linkReport struct {
+	Original string // As sent, scheme added to links typed without one
+	Cleaned  string
+	Changes  []linkChange
+}
+
+// describe renders the change as a line of an /explain diff
+func (c linkChange) describe(lang string) string {
+	var line string
+	switch c.Kind {
+	case changeQueryParam, changeMatrixParam:
+		removed := c.Key
+		if c.Value != "" {
+			removed += "=" + c.Value
+		}
+		line = tr(lang, "explain.change."+c.Kind, removed)
+	case changePathSegment, changeFragment:
+		line = tr(lang, "explain.change."+c.Kind, c.Key)
+	case changeExpansion:
+		line = tr(lang, "explain.change."+c.Kind, strings.Join(c.Chain, " → "))
+	case changeTikTokAlbum:
+		line = tr(lang, "explain.change."+c.Kind)
+	default: // Host and scheme rewrites
+		line = tr(lang, "explain.change."+c.Kind, c.From, c.To)
+	}
+	if c.Rule != "" {
+		line += " [" + c.Rule + "]"
+	}
+	return line
+}
+
+// explainLinks renders the links of a sanitizeURL result as a readable diff, one block per link
+func explainLinks(reports []linkReport, lang string) string {
+	blocks := make([]string, 0, len(reports))
+	for _, report := range reports {
+		if len(report.Changes) == 0 {
+			blocks = append(blocks, tr(lang, "explain.clean", report.Original))
+			continue
+		}
+		lines := []string{"- " + report.Original, "+ " + report.Cleaned}
+		for _, change := range report.Changes {
+			lines = append(lines, "  "+change.describe(lang))
+		}
+		blocks = append(blocks, strings.Join(lines, "\n"))
+	}
+	return strings.Join(blocks, "\n\n")
+}
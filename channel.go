@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// handleChannelPost cleans channel posts in place. Deleting and reposting would break the
+// channel's message order and view counts, so the post's text or caption is edited instead.
+func handleChannelPost(c tele.Context, b *tele.Bot) error {
+	m := c.Message()
+
+	text, entities := m.Text, m.Entities
+	isCaption := m.Media() != nil
+	if isCaption {
+		text, entities = m.Caption, m.CaptionEntities
+	}
+	settings := settingsFor(m.Chat.ID)
+	if text == "" || hasMarker(text, settings.NoCutMarker) {
+		return nil
+	}
+
+	result := sanitizeURL(text, entities, settings, skipPhotos)
+	if !result.Sanitized || !canEditChannelPosts(b, m.Chat) {
+		return nil
+	}
+
+	// Pass the post's buttons along, editing without them would remove them
+	editOpts := &tele.SendOptions{Entities: result.Entities, ReplyMarkup: m.ReplyMarkup}
+	var err error
+	if isCaption {
+		_, err = b.EditCaption(m, result.Text, editOpts)
+	} else {
+		_, err = b.Edit(m, result.Text, editOpts)
+	}
+	if err != nil {
+		log.Printf("Failed to edit channel post (ID: %d, ChatID: %d): %v", m.ID, m.Chat.ID, err)
+		return err
+	}
+	return nil
+}
+
+func handleEditedChannelPost(c tele.Context, b *tele.Bot) error {
+	if !settingsFor(c.Chat().ID).ProcessEdits {
+		return nil
+	}
+	return handleChannelPost(c, b) // Our own edit leaves nothing to clean, so this doesn't loop
+}
+
+func canEditChannelPosts(b *tele.Bot, chat *tele.Chat) bool {
+	member, err := botMember(b, chat)
+	if err != nil {
+		log.Printf("Failed to look up own rights in channel %d: %v", chat.ID, err)
+		return false
+	}
+	return member.Role == tele.Creator || (member.Role == tele.Administrator && member.CanEditMessages)
+}
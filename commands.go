@@ -0,0 +1,163 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"unicode"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// Commands shown in the command menu, each one has a "command.<name>" description in the catalog
+var (
+	privateCommands    = []string{"start", "help", "clean", "explain", "edit", "settings", "language", "about"}
+	groupCommands      = []string{"help", "clean", "explain", "edit", "about"}
+	groupAdminCommands = []string{"help", "clean", "explain", "edit", "settings", "attribution", "edits", "language", "about"}
+)
+
+// registerCommands publishes the command menu for private chats, groups and group admins in every language of the catalog
+func registerCommands(b *tele.Bot) {
+	scopes := []struct {
+		scope    tele.CommandScope
+		commands []string
+	}{
+		{tele.CommandScope{Type: tele.CommandScopeAllPrivateChats}, privateCommands},
+		{tele.CommandScope{Type: tele.CommandScopeAllGroupChats}, groupCommands},
+		{tele.CommandScope{Type: tele.CommandScopeAllChatAdmin}, groupAdminCommands},
+	}
+	for _, lang := range supportedLanguages() {
+		languageCode := lang
+		if lang == defaultLanguage {
+			languageCode = "" // Users of languages without translation get the default language
+		}
+		for _, s := range scopes {
+			commands := make([]tele.Command, 0, len(s.commands))
+			for _, name := range s.commands {
+				commands = append(commands, tele.Command{Text: name, Description: tr(lang, "command."+name)})
+			}
+			if err := b.SetCommands(commands, s.scope, languageCode); err != nil {
+				log.Printf("Warning: Failed to register %s commands for scope %s: %v", lang, s.scope.Type, err)
+			}
+		}
+	}
+}
+
+// isCommand reports whether m is a bot command, those are never sanitized and reposted
+func isCommand(m *tele.Message) bool {
+	return len(m.Entities) > 0 && m.Entities[0].Type == tele.EntityCommand && m.Entities[0].Offset == 0
+}
+
+// commandPayload returns the text after the command of m, with the entities remapped onto it
+func commandPayload(m *tele.Message) (string, tele.Entities) {
+	if !isCommand(m) {
+		return m.Text, m.Entities
+	}
+	commandEnd := byteOffset(m.Text, m.Entities[0].Length)
+	payloadStart := commandEnd + len(m.Text[commandEnd:]) - len(strings.TrimLeftFunc(m.Text[commandEnd:], unicode.IsSpace))
+	edit := textEdit{start: 0, end: utf16Len(m.Text[:payloadStart]), newLength: 0}
+	return m.Text[payloadStart:], remapEntities(m.Entities[1:], []textEdit{edit})
+}
+
+func handleStartCommand(c tele.Context) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	return c.Send(tr(lang, "start.text") + "\n\n" + tr(lang, "help.text"))
+}
+
+func handleHelpCommand(c tele.Context) error {
+	return c.Reply(tr(languageFor(c.Chat().ID, c.Sender()), "help.text"))
+}
+
+func handleAboutCommand(c tele.Context) error {
+	return c.Reply(tr(languageFor(c.Chat().ID, c.Sender()), "about.text"), tele.NoPreview)
+}
+
+// handleCleanCommand replies with the links after /clean cleaned, without deleting anything.
+// Sent as a reply without text, it cleans the replied-to message instead.
+func handleCleanCommand(c tele.Context, b *tele.Bot) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	text, entities := commandPayload(c.Message())
+	if strings.TrimSpace(text) == "" {
+		if target := c.Message().ReplyTo; target != nil && target.TopicCreated == nil { // Topic messages "reply" to the topic's first message
+			return cleanRepliedMessage(c, b, target)
+		}
+		return c.Reply(tr(lang, "clean.usage"))
+	}
+
+	result := sanitizeURL(text, entities, settingsFor(c.Chat().ID), skipPhotos)
+	if !result.Sanitized {
+		return c.Reply(tr(lang, "clean.nothing"))
+	}
+	return c.Reply(result.Text, &tele.SendOptions{Entities: result.Entities})
+}
+
+// cleanRepliedMessage cleans the text or caption of target. If the caller wrote target or is a chat admin,
+// target is reposted cleaned and deleted like a message the bot sanitized on arrival. Otherwise, or if
+// the bot can't delete messages, the cleaned text is posted as a reply to it.
+func cleanRepliedMessage(c tele.Context, b *tele.Bot, target *tele.Message) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	text, entities := target.Text, target.Entities
+	if target.Media() != nil {
+		text, entities = target.Caption, target.CaptionEntities
+	}
+	settings := settingsFor(target.Chat.ID)
+	result := sanitizeURL(text, entities, settings, skipPhotos)
+	if !result.Sanitized {
+		return c.Reply(tr(lang, "clean.nothing"))
+	}
+
+	if (!isAuthor(c.Message(), target) && !isChatAdmin(b, c.Message())) || !canDeleteMessages(b, target.Chat) {
+		return sendSuggestion(b, target, result)
+	}
+
+	repostText, repostEntities := attributeMessage(target, result)
+	sendOpts := repostOptions(target, result)
+	sendOpts.Entities = repostEntities
+	offerUndo(sendOpts, target)
+	offerDelete(sendOpts)
+	var repost *tele.Message
+	var err error
+	if target.Media() != nil {
+		media := mediaWithCaption(target, repostText)
+		if media == nil {
+			return c.Reply(tr(lang, "clean.unsupported"))
+		}
+		sendOpts.HasSpoiler = target.HasMediaSpoiler
+		repost, err = sendMediaRepost(b, target, media, sendOpts, repostReply(target))
+	} else {
+		repost, err = sendText(b, target.Chat, repostText, sendOpts, repostReply(target))
+	}
+	if err != nil {
+		log.Printf("Failed to send cleaned message to chat %d: %v", target.Chat.ID, err)
+		return err
+	}
+	recordRepost(repost, target)
+
+	markHandled(target)
+	deleteOriginal(b, target)
+	return nil
+}
+
+// isAuthor reports whether m and target were sent by the same user, or on behalf of the same chat
+func isAuthor(m, target *tele.Message) bool {
+	if m.SenderChat != nil || target.SenderChat != nil {
+		return m.SenderChat != nil && target.SenderChat != nil && m.SenderChat.ID == target.SenderChat.ID
+	}
+	return m.Sender != nil && target.Sender != nil && m.Sender.ID == target.Sender.ID
+}
+
+// handleExplainCommand shows what cleaning the links after /explain removes and which rule matched
+func handleExplainCommand(c tele.Context) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	text, entities := commandPayload(c.Message())
+	result := sanitizeURL(text, entities, settingsFor(c.Chat().ID), skipPhotos)
+	if len(result.Links) == 0 {
+		return c.Reply(tr(lang, "explain.usage"))
+	}
+	explanation := explainLinks(result.Links, lang)
+	return c.Reply(explanation, &tele.SendOptions{Entities: diffEntities(explanation)})
+}
+
+// diffEntities formats an explainLinks text as a diff code block
+func diffEntities(explanation string) tele.Entities {
+	return tele.Entities{{Type: tele.EntityCodeBlock, Offset: 0, Length: utf16Len(explanation), Language: "diff"}}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// handledMessageTTL is how long reposted messages are remembered, so later edits don't repost them again
+const handledMessageTTL = 48 * time.Hour
+
+type messageKey struct {
+	chatID    int64
+	messageID int
+}
+
+// handledMessages remembers the messages the bot already reposted (e.g. when it couldn't delete them)
+var handledMessages = struct {
+	sync.Mutex
+	at map[messageKey]time.Time
+}{at: make(map[messageKey]time.Time)}
+
+func markHandled(m *tele.Message) {
+	handledMessages.Lock()
+	defer handledMessages.Unlock()
+
+	dropExpired(handledMessages.at, handledMessageTTL, func(handledAt time.Time) time.Time { return handledAt })
+	handledMessages.at[messageKey{chatID: m.Chat.ID, messageID: m.ID}] = time.Now()
+}
+
+func wasHandled(m *tele.Message) bool {
+	handledMessages.Lock()
+	defer handledMessages.Unlock()
+	handledAt, ok := handledMessages.at[messageKey{chatID: m.Chat.ID, messageID: m.ID}]
+	return ok && time.Since(handledAt) <= handledMessageTTL
+}
+
+// handleEditedMessage runs edited messages through the same pipeline as new ones
+func handleEditedMessage(c tele.Context, b *tele.Bot) error {
+	m := c.Message()
+	if !settingsFor(m.Chat.ID).ProcessEdits || wasHandled(m) {
+		return nil
+	}
+	if m.Media() != nil {
+		if m.AlbumID != "" {
+			return nil // Reposting a single edited item would tear the album apart
+		}
+		return handleMediaMessage(c, b)
+	}
+	return handleTextMessage(c, b)
+}
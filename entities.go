@@ -0,0 +1,228 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// linkSpan marks a link (or another entity) inside a message text by byte offsets
+type linkSpan struct {
+	start, end int
+}
+
+// textEdit describes replacing the UTF-16 range [start, end) of a text with newLength UTF-16 units
+type textEdit struct {
+	start, end, newLength int
+}
+
+// bareURLPattern matches links typed without a scheme, e.g. "youtu.be/abc?si=x" or "www.amazon.de/dp/..."
+var bareURLPattern = regexp.MustCompile(`(?i)^((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,24}))(?::\d{1,5})?([/?#]\S*)?$`)
+
+const (
+	linkOpeningPunctuation  = "([{<\"'«„“‚‘"
+	linkTrailingPunctuation = ".,;:!?\"'»“”‘’…"
+)
+
+// linkBracketPairs maps closing brackets to their opening counterpart. A closing bracket
+// is only part of a link if it is balanced inside it, e.g. wikipedia.org/wiki/Go_(programming_language)
+var linkBracketPairs = map[rune]rune{')': '(', ']': '[', '}': '{', '>': '<'}
+
+// commonTLDs are accepted for bare hosts without a path, so "amazon.de" is a link but "node.js" is not
+var commonTLDs = map[string]bool{
+	"com": true, "org": true, "net": true, "io": true, "be": true, "de": true, "at": true, "ch": true,
+	"uk": true, "fr": true, "es": true, "it": true, "nl": true, "eu": true, "me": true, "tv": true,
+	"ru": true, "us": true, "co": true, "app": true, "dev": true, "gg": true, "to": true, "ly": true,
+}
+
+// findLinks returns the links in text. Telegram's own "url" entities are used when available,
+// otherwise the text is tokenized the way Telegram detects links (see linkInWord).
+// Links inside code and pre entities are left alone, they are usually pasted on purpose.
+func findLinks(text string, entities tele.Entities) []linkSpan {
+	spans := findLinkCandidates(text, entities)
+	codeSpans := entitySpans(text, entities, tele.EntityCode, tele.EntityCodeBlock)
+	if len(codeSpans) == 0 {
+		return spans
+	}
+	return slices.DeleteFunc(spans, func(link linkSpan) bool {
+		return slices.ContainsFunc(codeSpans, link.overlaps)
+	})
+}
+
+func findLinkCandidates(text string, entities tele.Entities) []linkSpan {
+	var spans []linkSpan
+	if len(entities) > 0 {
+		for _, entity := range entities {
+			if entity.Type != tele.EntityURL {
+				continue
+			}
+			start, end := byteOffset(text, entity.Offset), byteOffset(text, entity.Offset+entity.Length)
+			if start < end {
+				spans = append(spans, linkSpan{start: start, end: end})
+			}
+		}
+		slices.SortFunc(spans, func(a, b linkSpan) int { return a.start - b.start })
+		return spans
+	}
+
+	start := -1
+	for i, r := range text + " " { // Trailing space flushes the last word
+		if !unicode.IsSpace(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			if linkStart, linkEnd, ok := linkInWord(text[start:i]); ok {
+				spans = append(spans, linkSpan{start: start + linkStart, end: start + linkEnd})
+			}
+			start = -1
+		}
+	}
+	return spans
+}
+
+// linkInWord finds the link inside a whitespace-delimited word, leaving surrounding
+// punctuation and brackets outside of it: "(https://x.com/a?s=20)." yields "https://x.com/a?s=20".
+func linkInWord(word string) (start, end int, ok bool) {
+	start = strings.Index(word, "https://")
+	if httpStart := strings.Index(word, "http://"); httpStart >= 0 && (start < 0 || httpStart < start) {
+		start = httpStart
+	}
+	if start < 0 { // No scheme, skip opening punctuation in front of a bare link
+		start = strings.IndexFunc(word, func(r rune) bool { return !strings.ContainsRune(linkOpeningPunctuation, r) })
+		if start < 0 {
+			return 0, 0, false
+		}
+	}
+
+	end = len(word)
+	for end > start {
+		last, size := utf8.DecodeLastRuneInString(word[start:end])
+		if strings.ContainsRune(linkTrailingPunctuation, last) {
+			end -= size
+			continue
+		}
+		if opening, isClosing := linkBracketPairs[last]; isClosing && strings.Count(word[start:end], string(opening)) < strings.Count(word[start:end], string(last)) {
+			end -= size // Unbalanced closing bracket belongs to the surrounding text
+			continue
+		}
+		break
+	}
+
+	link := word[start:end]
+	if !(containsURL(link) && strings.Contains(link, ".")) && !isBareURL(link) {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// entitySpans returns the byte ranges of all entities of the given types
+func entitySpans(text string, entities tele.Entities, types ...tele.EntityType) []linkSpan {
+	var spans []linkSpan
+	for _, entity := range entities {
+		if slices.Contains(types, entity.Type) {
+			spans = append(spans, linkSpan{start: byteOffset(text, entity.Offset), end: byteOffset(text, entity.Offset+entity.Length)})
+		}
+	}
+	return spans
+}
+
+func (s linkSpan) overlaps(other linkSpan) bool {
+	return s.start < other.end && other.start < s.end
+}
+
+func isBareURL(word string) bool {
+	match := bareURLPattern.FindStringSubmatch(word)
+	if match == nil {
+		return false
+	}
+	host, tld, rest := strings.ToLower(match[1]), strings.ToLower(match[2]), match[3]
+	return strings.HasPrefix(host, "www.") || rest != "" || commonTLDs[tld]
+}
+
+// remapEntities returns a copy of entities with offsets and lengths adjusted for edits.
+// Edits and entities both refer to offsets in the original text, edits are sorted and don't overlap.
+// Entities that only partially overlap an edit are clamped to the end of the replacement.
+func remapEntities(entities tele.Entities, edits []textEdit) tele.Entities {
+	if entities == nil {
+		return nil
+	}
+	remapped := make(tele.Entities, 0, len(entities))
+	for _, entity := range entities {
+		offset := remapOffset(entity.Offset, edits)
+		length := remapOffset(entity.Offset+entity.Length, edits) - offset
+		if length <= 0 {
+			continue // Entity covered only removed text
+		}
+		entity.Offset, entity.Length = offset, length
+		remapped = append(remapped, entity)
+	}
+	return remapped
+}
+
+// remapOffset maps an offset in the original text to the edited text.
+// Offsets inside an edit land at the end of its replacement.
+func remapOffset(offset int, edits []textEdit) int {
+	shift := 0
+	for _, edit := range edits {
+		switch {
+		case offset >= edit.end: // Offset after the edit moves
+			shift += edit.newLength - (edit.end - edit.start)
+		case offset > edit.start: // Offset inside the edit
+			return edit.start + shift + edit.newLength
+		default:
+			return offset + shift
+		}
+	}
+	return offset + shift
+}
+
+// cutText removes the first occurrence of marker from text and remaps entities accordingly
+func cutText(text string, entities tele.Entities, marker string) (string, tele.Entities) {
+	idx := strings.Index(text, marker)
+	if idx < 0 {
+		return text, entities
+	}
+	start := utf16Len(text[:idx])
+	edit := textEdit{start: start, end: start + utf16Len(marker), newLength: 0}
+	return text[:idx] + text[idx+len(marker):], remapEntities(entities, []textEdit{edit})
+}
+
+// prependText puts prefix in front of text and moves entities behind it
+func prependText(prefix, text string, entities tele.Entities) (string, tele.Entities) {
+	return prefix + text, remapEntities(entities, []textEdit{{start: 0, end: 0, newLength: utf16Len(prefix)}})
+}
+
+// utf16Len returns the length of s in UTF-16 code units, the unit Telegram uses for entity offsets
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += runeUTF16Len(r)
+	}
+	return n
+}
+
+// byteOffset converts a UTF-16 offset into a byte offset within s
+func byteOffset(s string, utf16Offset int) int {
+	units := 0
+	for i, r := range s {
+		if units >= utf16Offset {
+			return i
+		}
+		units += runeUTF16Len(r)
+	}
+	return len(s)
+}
+
+func runeUTF16Len(r rune) int {
+	if r >= 0x10000 { // Encoded as a surrogate pair
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+func TestLinkInWord(t *testing.T) {
+	tests := []struct {
+		word string
+		link string // Empty if word holds no link
+	}{
+		{word: "https://x.com/a?s=20", link: "https://x.com/a?s=20"},
+		{word: "(https://x.com/a?s=20).", link: "https://x.com/a?s=20"},
+		{word: "see:https://x.com/a", link: "https://x.com/a"},
+		{word: "https://x.com/a,", link: "https://x.com/a"},
+		{word: "https://x.com/a?!", link: "https://x.com/a"},
+		{word: "HTTPS://x.com/a", link: "HTTPS://x.com/a"},
+		{word: "https://en.wikipedia.org/wiki/Go_(programming_language)", link: "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+		{word: "(https://en.wikipedia.org/wiki/Go_(programming_language))", link: "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+		{word: "[example.com/a]", link: "example.com/a"},
+		{word: "«youtu.be/abc?si=x»", link: "youtu.be/abc?si=x"},
+		{word: "\"www.amazon.de\"", link: "www.amazon.de"},
+		{word: "amazon.de", link: "amazon.de"},
+		{word: "example.com:8080/a", link: "example.com:8080/a"},
+		{word: "node.js", link: ""},
+		{word: "hello", link: ""},
+		{word: "...", link: ""},
+	}
+	for _, tt := range tests {
+		start, end, ok := linkInWord(tt.word)
+		var link string
+		if ok {
+			link = tt.word[start:end]
+		}
+		if link != tt.link {
+			t.Errorf("linkInWord(%q) = %q, want %q", tt.word, link, tt.link)
+		}
+	}
+}
+
+func TestFindLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		entities tele.Entities
+		links    []string
+	}{
+		{
+			name:  "plain text",
+			text:  "look (https://x.com/a?s=20), and youtu.be/abc?si=x.",
+			links: []string{"https://x.com/a?s=20", "youtu.be/abc?si=x"},
+		},
+		{
+			name:  "emoji before link",
+			text:  "😀 👍🏽 https://x.com/a",
+			links: []string{"https://x.com/a"},
+		},
+		{
+			name:     "url entity after emoji",
+			text:     "😀 https://x.com/a ok",
+			entities: tele.Entities{{Type: tele.EntityURL, Offset: 3, Length: 15}},
+			links:    []string{"https://x.com/a"},
+		},
+		{
+			name:     "url entity with uppercase scheme",
+			text:     "HTTPS://x.com/a",
+			entities: tele.Entities{{Type: tele.EntityURL, Offset: 0, Length: 15}},
+			links:    []string{"HTTPS://x.com/a"},
+		},
+		{
+			name: "link in code is skipped",
+			text: "https://x.com/a https://x.com/b",
+			entities: tele.Entities{
+				{Type: tele.EntityURL, Offset: 0, Length: 15},
+				{Type: tele.EntityCode, Offset: 16, Length: 15},
+				{Type: tele.EntityURL, Offset: 16, Length: 15},
+			},
+			links: []string{"https://x.com/a"},
+		},
+	}
+	for _, tt := range tests {
+		var links []string
+		for _, span := range findLinks(tt.text, tt.entities) {
+			links = append(links, tt.text[span.start:span.end])
+		}
+		if !slices.Equal(links, tt.links) {
+			t.Errorf("%s: findLinks(%q) = %q, want %q", tt.name, tt.text, links, tt.links)
+		}
+	}
+}
+
+func TestRemapEntities(t *testing.T) {
+	// "hi https://example.com/a?utm_source=abcdef yo end" with the link [3, 43) cleaned to
+	// "https://example.com/a" (21 units)
+	linkEdit := []textEdit{{start: 3, end: 43, newLength: 21}}
+	tests := []struct {
+		name     string
+		entities tele.Entities
+		edits    []textEdit
+		want     tele.Entities
+	}{
+		{
+			name:     "before the edit",
+			entities: tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 2}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 2}},
+		},
+		{
+			name:     "after the edit",
+			entities: tele.Entities{{Type: tele.EntityBold, Offset: 44, Length: 2}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityBold, Offset: 25, Length: 2}},
+		},
+		{
+			name:     "around the edit",
+			entities: tele.Entities{{Type: tele.EntityItalic, Offset: 0, Length: 46}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityItalic, Offset: 0, Length: 27}},
+		},
+		{
+			name:     "exactly the edit",
+			entities: tele.Entities{{Type: tele.EntityURL, Offset: 3, Length: 40}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityURL, Offset: 3, Length: 21}},
+		},
+		{
+			name:     "starts inside the edit",
+			entities: tele.Entities{{Type: tele.EntityItalic, Offset: 30, Length: 16}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityItalic, Offset: 24, Length: 3}},
+		},
+		{
+			name:     "ends inside the edit",
+			entities: tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 10}},
+			edits:    linkEdit,
+			want:     tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 24}},
+		},
+		{
+			name:     "inside the edit",
+			entities: tele.Entities{{Type: tele.EntityItalic, Offset: 30, Length: 5}},
+			edits:    linkEdit,
+			want:     tele.Entities{},
+		},
+		{
+			name:     "several edits",
+			entities: tele.Entities{{Type: tele.EntityBold, Offset: 12, Length: 3}, {Type: tele.EntityItalic, Offset: 0, Length: 30}},
+			edits:    []textEdit{{start: 2, end: 10, newLength: 4}, {start: 20, end: 25, newLength: 8}},
+			want:     tele.Entities{{Type: tele.EntityBold, Offset: 8, Length: 3}, {Type: tele.EntityItalic, Offset: 0, Length: 29}},
+		},
+		{
+			name:     "nil stays nil",
+			entities: nil,
+			edits:    linkEdit,
+			want:     nil,
+		},
+	}
+	for _, tt := range tests {
+		got := remapEntities(tt.entities, tt.edits)
+		if !slices.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+			t.Errorf("%s: remapEntities() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCutText(t *testing.T) {
+	tests := []struct {
+		name         string
+		text         string
+		entities     tele.Entities
+		marker       string
+		wantText     string
+		wantEntities tele.Entities
+	}{
+		{
+			name:         "marker in front",
+			text:         "anon hello",
+			entities:     tele.Entities{{Type: tele.EntityBold, Offset: 5, Length: 5}},
+			marker:       "anon ",
+			wantText:     "hello",
+			wantEntities: tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 5}},
+		},
+		{
+			name:         "marker after emoji",
+			text:         "😀 anon https://x.com",
+			entities:     tele.Entities{{Type: tele.EntityURL, Offset: 8, Length: 13}},
+			marker:       "anon ",
+			wantText:     "😀 https://x.com",
+			wantEntities: tele.Entities{{Type: tele.EntityURL, Offset: 3, Length: 13}},
+		},
+		{
+			name:         "entity only on the marker",
+			text:         "hi anon",
+			entities:     tele.Entities{{Type: tele.EntityItalic, Offset: 3, Length: 4}},
+			marker:       "anon",
+			wantText:     "hi ",
+			wantEntities: tele.Entities{},
+		},
+		{
+			name:         "no marker",
+			text:         "hello",
+			entities:     tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 5}},
+			marker:       "anon",
+			wantText:     "hello",
+			wantEntities: tele.Entities{{Type: tele.EntityBold, Offset: 0, Length: 5}},
+		},
+	}
+	for _, tt := range tests {
+		text, entities := cutText(tt.text, tt.entities, tt.marker)
+		if text != tt.wantText || !slices.Equal(entities, tt.wantEntities) {
+			t.Errorf("%s: cutText() = %q, %+v, want %q, %+v", tt.name, text, entities, tt.wantText, tt.wantEntities)
+		}
+	}
+}
+
+func TestPrependText(t *testing.T) {
+	tests := []struct {
+		name         string
+		prefix       string
+		text         string
+		entities     tele.Entities
+		wantEntities tele.Entities
+	}{
+		{
+			name:         "ascii prefix",
+			prefix:       "@bob said: ",
+			text:         "https://x.com",
+			entities:     tele.Entities{{Type: tele.EntityURL, Offset: 0, Length: 13}},
+			wantEntities: tele.Entities{{Type: tele.EntityURL, Offset: 11, Length: 13}},
+		},
+		{
+			name:         "emoji prefix",
+			prefix:       "😀 Ünïcode: ",
+			text:         "hi there",
+			entities:     tele.Entities{{Type: tele.EntityBold, Offset: 3, Length: 5}},
+			wantEntities: tele.Entities{{Type: tele.EntityBold, Offset: 15, Length: 5}},
+		},
+	}
+	for _, tt := range tests {
+		text, entities := prependText(tt.prefix, tt.text, tt.entities)
+		if text != tt.prefix+tt.text || !slices.Equal(entities, tt.wantEntities) {
+			t.Errorf("%s: prependText() = %q, %+v, want %+v", tt.name, text, entities, tt.wantEntities)
+		}
+	}
+}
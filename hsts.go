@@ -0,0 +1,51 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"sync"
+)
+
+var (
+	hstsLoadOnce sync.Once
+	hstsHosts    map[string]bool // Host -> includeSubdomains
+)
+
+func loadHSTSHosts() {
+	hstsHosts = make(map[string]bool)
+	for _, rule := range HSTSRules { // "*.example.com" covers example.com and all of its subdomains
+		if host, found := strings.CutPrefix(rule, "*."); found {
+			hstsHosts[host] = true
+		} else if _, exists := hstsHosts[rule]; !exists {
+			hstsHosts[rule] = false
+		}
+	}
+}
+
+func isHSTSHost(host string) bool {
+	hstsLoadOnce.Do(loadHSTSHosts)
+
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if _, ok := hstsHosts[host]; ok {
+		return true
+	}
+	for dot := strings.IndexByte(host, '.'); dot >= 0; dot = strings.IndexByte(host, '.') { // Walk up the parent domains
+		host = host[dot+1:]
+		if includeSubdomains, ok := hstsHosts[host]; ok && includeSubdomains {
+			return true
+		}
+	}
+	return false
+}
+
+// upgradeToHTTPS rewrites http:// to https:// if the host is known to only serve HTTPS.
+func upgradeToHTTPS(u *url.URL) bool {
+	if u.Scheme != "http" || !isHSTSHost(u.Hostname()) {
+		return false
+	}
+	u.Scheme = "https"
+	if u.Port() == "80" {
+		u.Host = u.Hostname()
+	}
+	return true
+}
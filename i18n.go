@@ -0,0 +1,102 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// localeFiles holds one translation file per language, named after its ISO 639-1 code (e.g. "de.json").
+// Each file maps message keys to fmt format strings, missing keys fall back to defaultLanguage.
+//
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+const defaultLanguage = "en"
+
+var (
+	catalogLoadOnce sync.Once
+	catalog         map[string]map[string]string // Language -> message key -> format string
+)
+
+func loadCatalog() {
+	catalog = make(map[string]map[string]string)
+
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		log.Printf("Warning: Failed to read embedded translations: %v. Message keys will be shown instead.", err)
+		return
+	}
+	for _, file := range files {
+		lang := strings.TrimSuffix(file.Name(), path.Ext(file.Name()))
+		data, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			log.Printf("Warning: Failed to read translation file %s: %v", file.Name(), err)
+			continue
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(data, &messages); err != nil {
+			log.Printf("Warning: Failed to parse translation file %s: %v", file.Name(), err)
+			continue
+		}
+		catalog[lang] = messages
+	}
+}
+
+// tr returns the message for key in lang, formatted with args
+func tr(lang, key string, args ...any) string {
+	catalogLoadOnce.Do(loadCatalog)
+
+	format, ok := catalog[lang][key]
+	if !ok {
+		if format, ok = catalog[defaultLanguage][key]; !ok {
+			log.Printf("Warning: Missing translation for message key %q", key)
+			format = key
+		}
+	}
+	if len(args) == 0 {
+		return format
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// supportedLanguages returns the codes of all languages with a translation file, sorted
+func supportedLanguages() []string {
+	catalogLoadOnce.Do(loadCatalog)
+
+	languages := make([]string, 0, len(catalog))
+	for lang := range catalog {
+		languages = append(languages, lang)
+	}
+	slices.Sort(languages)
+	return languages
+}
+
+func isSupportedLanguage(lang string) bool {
+	catalogLoadOnce.Do(loadCatalog)
+
+	_, ok := catalog[lang]
+	return ok
+}
+
+// languageFor picks the language of bot messages in a chat: the chat's language setting if
+// an admin chose one, otherwise the language of the user's Telegram client.
+func languageFor(chatID int64, user *tele.User) string {
+	if lang := settingsFor(chatID).Language; lang != "" {
+		return lang
+	}
+	if user != nil {
+		lang, _, _ := strings.Cut(strings.ToLower(user.LanguageCode), "-") // "de-AT" -> "de"
+		if isSupportedLanguage(lang) {
+			return lang
+		}
+	}
+	return defaultLanguage
+}
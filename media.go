@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// albumCollectWindow is how long the items of a media group are collected after the last one arrived
+const albumCollectWindow = 1500 * time.Millisecond
+
+// albumBuffer collects the items of user-sent media groups, which Telegram delivers as separate updates
+type albumBuffer struct {
+	mu      sync.Mutex
+	pending map[string]*pendingAlbum // Keyed by "<chat ID>:<album ID>"
+}
+
+type pendingAlbum struct {
+	messages []*tele.Message
+	timer    *time.Timer
+}
+
+var mediaGroups = &albumBuffer{pending: make(map[string]*pendingAlbum)}
+
+// add buffers m and calls flush with all items of its media group once no new item arrived for albumCollectWindow.
+func (ab *albumBuffer) add(m *tele.Message, flush func([]*tele.Message)) {
+	ab.mu.Lock()
+	defer ab.mu.Unlock()
+
+	key := fmt.Sprintf("%d:%s", m.Chat.ID, m.AlbumID)
+	album, ok := ab.pending[key]
+	if ok {
+		album.timer.Reset(albumCollectWindow)
+	} else {
+		album = &pendingAlbum{}
+		album.timer = time.AfterFunc(albumCollectWindow, func() {
+			ab.mu.Lock()
+			messages := album.messages
+			delete(ab.pending, key)
+			ab.mu.Unlock()
+			flush(messages)
+		})
+		ab.pending[key] = album
+	}
+	album.messages = append(album.messages, m)
+}
+
+func handleMediaMessage(c tele.Context, b *tele.Bot) error {
+	sender := c.Sender()
+	if sender == nil {
+		log.Println("Warning: Received media message without sender information.")
+		return nil
+	}
+	m := c.Message()
+	if m.AutomaticForward {
+		return nil // handleChannelPost cleans the channel post, reposting its copy would cut it off from its comments
+	}
+
+	if m.AlbumID != "" { // Part of a media group, handled as a whole once all items arrived
+		mediaGroups.add(m, func(messages []*tele.Message) {
+			handleMediaGroup(b, messages)
+		})
+		return nil
+	}
+
+	settings := settingsFor(m.Chat.ID)
+	if m.Caption == "" || hasMarker(m.Caption, settings.NoCutMarker) {
+		return nil // Nothing to clean or "nocut" keyword present.
+	}
+
+	result := sanitizeURL(m.Caption, m.CaptionEntities, settings, skipPhotos)
+	if !result.Sanitized {
+		return nil
+	}
+	if suggestOnly(b, m.Chat, settings) {
+		return sendSuggestion(b, m, result)
+	}
+
+	caption, captionEntities := attributeMessage(m, result)
+	media := mediaWithCaption(m, caption)
+	if media == nil {
+		return nil // Not a media type we can repost
+	}
+
+	sendOpts := repostOptions(m, result)
+	sendOpts.Entities = captionEntities
+	sendOpts.HasSpoiler = m.HasMediaSpoiler
+	if settings.DeleteOriginal {
+		offerUndo(sendOpts, m)
+	}
+	offerDelete(sendOpts)
+	repost, err := sendMediaRepost(b, m, media, sendOpts, repostReply(m))
+	if err != nil {
+		log.Printf("Failed to send sanitized media to chat %d: %v", c.Chat().ID, err)
+		return err
+	}
+	recordRepost(repost, m)
+
+	markHandled(m)
+	if settings.DeleteOriginal {
+		deleteOriginal(b, m)
+	}
+	return nil
+}
+
+// handleMediaGroup sanitizes the caption of a user-sent album once and reposts all of its items together.
+func handleMediaGroup(b *tele.Bot, messages []*tele.Message) {
+	slices.SortFunc(messages, func(a, b *tele.Message) int { return a.ID - b.ID })
+
+	captionIdx := slices.IndexFunc(messages, func(m *tele.Message) bool { return m.Caption != "" })
+	if captionIdx < 0 {
+		return // Albums without a caption have nothing to clean
+	}
+	captioned := messages[captionIdx]
+	settings := settingsFor(captioned.Chat.ID)
+	if captioned.Sender == nil || slices.ContainsFunc(messages, func(m *tele.Message) bool { return hasMarker(m.Caption, settings.NoCutMarker) }) {
+		return
+	}
+
+	// Every item can carry its own caption (e.g. albums sent from the desktop apps), each one is cleaned
+	results := make([]sanitizeResult, len(messages))
+	sanitized := false
+	for i, m := range messages {
+		if m.Caption == "" {
+			continue
+		}
+		results[i] = sanitizeURL(m.Caption, m.CaptionEntities, settings, skipPhotos)
+		sanitized = sanitized || results[i].Sanitized
+	}
+	if !sanitized {
+		return
+	}
+	if suggestOnly(b, captioned.Chat, settings) {
+		for i, m := range messages {
+			if results[i].Sanitized {
+				sendSuggestion(b, m, results[i]) // Failures are logged
+			}
+		}
+		return
+	}
+
+	album := make(tele.Album, 0, len(messages))
+	originals := make([]tele.Editable, 0, len(messages))
+	for i, m := range messages {
+		itemCaption, itemEntities := m.Caption, m.CaptionEntities
+		if results[i].Sanitized {
+			itemCaption, itemEntities = results[i].Text, results[i].Entities
+		}
+		if i == captionIdx { // The attribution goes above the first caption only
+			itemCaption, itemEntities = attributeMessage(m, sanitizeResult{Text: itemCaption, Entities: itemEntities})
+		}
+		media := mediaWithCaption(m, itemCaption)
+		if media == nil {
+			log.Printf("Warning: Album %s in chat %d contains an unsupported item (ID: %d), leaving it as is.", m.AlbumID, m.Chat.ID, m.ID)
+			return
+		}
+		album = append(album, captionedMedia{Inputtable: media, entities: itemEntities})
+		originals = append(originals, m)
+	}
+
+	sendOpts := repostOptions(messages[0], sanitizeResult{})
+	sendOpts.ReplyMarkup = nil // Media groups can't carry buttons
+	if _, err := sendAlbumRepost(b, captioned.Chat, album, sendOpts, repostReply(messages[0])); err != nil {
+		log.Printf("Failed to send sanitized album to chat %d: %v", captioned.Chat.ID, err)
+		return
+	}
+
+	for _, m := range messages {
+		markHandled(m)
+	}
+	if !settings.DeleteOriginal {
+		return
+	}
+	if err := b.DeleteMany(originals); err != nil {
+		log.Printf("Failed to delete original album %s (ChatID: %d): %v", captioned.AlbumID, captioned.Chat.ID, err)
+	}
+}
+
+// mediaWithCaption returns a copy of the message's media with a new caption. The copy keeps
+// the file ID, so Telegram reuses the uploaded file instead of the bot uploading it again.
+func mediaWithCaption(m *tele.Message, caption string) tele.Inputtable {
+	switch {
+	case m.Photo != nil:
+		photo := *m.Photo
+		photo.Caption, photo.CaptionAbove = caption, m.CaptionAbove
+		photo.HasSpoiler = m.HasMediaSpoiler
+		return &photo
+	case m.Video != nil:
+		video := *m.Video
+		video.Caption, video.CaptionAbove = caption, m.CaptionAbove
+		video.HasSpoiler = m.HasMediaSpoiler
+		video.Thumbnail = nil // Thumbnails can only be uploaded, not reused by file ID
+		return &video
+	case m.Animation != nil: // Animations also carry a Document, so check them first
+		animation := *m.Animation
+		animation.Caption, animation.CaptionAbove = caption, m.CaptionAbove
+		animation.HasSpoiler = m.HasMediaSpoiler
+		animation.Thumbnail = nil
+		return &animation
+	case m.Audio != nil:
+		audio := *m.Audio
+		audio.Caption = caption
+		audio.Thumbnail = nil
+		return &audio
+	case m.Document != nil:
+		document := *m.Document
+		document.Caption = caption
+		document.Thumbnail = nil
+		return &document
+	}
+	return nil
+}
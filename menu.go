@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	"slices"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// settingsCallbackUnique identifies the buttons of the /settings menu, their data names the setting
+const settingsCallbackUnique = "settings"
+
+const settingAttribution = "attribution" // Callback data of the attribution style button
+
+// settingToggle is an on/off setting of the /settings menu
+type settingToggle struct {
+	key   string // Callback data and suffix of the "settings.<key>" message key
+	field func(*ChatSettings) *bool
+}
+
+var settingToggles = []settingToggle{
+	{"delete_original", func(s *ChatSettings) *bool { return &s.DeleteOriginal }},
+	{"original_link_buttons", func(s *ChatSettings) *bool { return &s.OriginalLinkButtons }},
+	{"frontend_tiktok", func(s *ChatSettings) *bool { return &s.FrontendTikTok }},
+	{"frontend_x", func(s *ChatSettings) *bool { return &s.FrontendX }},
+	{"frontend_instagram", func(s *ChatSettings) *bool { return &s.FrontendInstagram }},
+	{"tiktok_albums", func(s *ChatSettings) *bool { return &s.TikTokAlbums }},
+	{"suggest_only", func(s *ChatSettings) *bool { return &s.SuggestOnly }},
+	{"process_edits", func(s *ChatSettings) *bool { return &s.ProcessEdits }},
+}
+
+// attributionStyles are cycled through by the attribution button. They contain no words, so
+// they read the same in every language. Custom templates can still be set with /attribution.
+var attributionStyles = []string{defaultAttributionTemplate, placeholderMention + ":", placeholderName + ":", ""}
+
+func handleSettingsCommand(c tele.Context, b *tele.Bot) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	settings := settingsFor(c.Chat().ID)
+	if !isChatAdmin(b, c.Message()) {
+		return c.Reply(settingsOverview(settings, lang))
+	}
+	return c.Reply(tr(lang, "settings.title"), &tele.ReplyMarkup{InlineKeyboard: settingsKeyboard(settings, lang)})
+}
+
+func handleSettingsCallback(c tele.Context, b *tele.Bot) error {
+	chat, sender := c.Chat(), c.Sender()
+	if chat == nil { // The menu message is too old to be accessible
+		return c.Respond()
+	}
+	lang := languageFor(chat.ID, sender)
+	if chat.Type != tele.ChatPrivate && !isChatAdminUser(b, chat, sender) {
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "command.admin_only"), ShowAlert: true})
+	}
+
+	key := c.Callback().Data
+	update := func(s *ChatSettings) {
+		if key == settingAttribution {
+			next := (slices.Index(attributionStyles, s.AttributionTemplate) + 1) % len(attributionStyles) // Custom templates continue with the default
+			s.AttributionTemplate = attributionStyles[next]
+			return
+		}
+		if idx := slices.IndexFunc(settingToggles, func(t settingToggle) bool { return t.key == key }); idx >= 0 {
+			field := settingToggles[idx].field(s)
+			*field = !*field
+		}
+	}
+	if err := updateSettings(chat.ID, update); err != nil {
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "command.save_failed"), ShowAlert: true})
+	}
+
+	if err := c.Edit(tr(lang, "settings.title"), &tele.ReplyMarkup{InlineKeyboard: settingsKeyboard(settingsFor(chat.ID), lang)}); err != nil {
+		log.Printf("Failed to update settings menu in chat %d: %v", chat.ID, err)
+	}
+	return c.Respond(&tele.CallbackResponse{Text: tr(lang, "settings.saved")})
+}
+
+func settingsKeyboard(settings ChatSettings, lang string) [][]tele.InlineButton {
+	rows := make([][]tele.InlineButton, 0, len(settingToggles)+1)
+	for _, toggle := range settingToggles {
+		rows = append(rows, []tele.InlineButton{{
+			Unique: settingsCallbackUnique,
+			Text:   toggleLabel(toggle, settings, lang),
+			Data:   toggle.key,
+		}})
+	}
+	rows = append(rows, []tele.InlineButton{{
+		Unique: settingsCallbackUnique,
+		Text:   tr(lang, "settings.attribution", describeAttribution(settings.AttributionTemplate, lang)),
+		Data:   settingAttribution,
+	}})
+	return rows
+}
+
+// settingsOverview lists the settings for members that can't change them
+func settingsOverview(settings ChatSettings, lang string) string {
+	lines := []string{tr(lang, "settings.title_read_only")}
+	for _, toggle := range settingToggles {
+		lines = append(lines, toggleLabel(toggle, settings, lang))
+	}
+	lines = append(lines, tr(lang, "settings.attribution", describeAttribution(settings.AttributionTemplate, lang)))
+	return strings.Join(lines, "\n")
+}
+
+func toggleLabel(toggle settingToggle, settings ChatSettings, lang string) string {
+	state := "❌ "
+	if *toggle.field(&settings) {
+		state = "✅ "
+	}
+	return state + tr(lang, "settings."+toggle.key)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// deleteCallbackUnique identifies the 🗑 buttons of reposts
+const deleteCallbackUnique = "delete"
+
+// repostOwnerTTL is how long the sender of a repost can delete or edit it. Bots can only delete
+// their messages in groups for 48 hours, so there's no point in remembering them longer.
+const repostOwnerTTL = 48 * time.Hour
+
+// repostOwner records who a repost of the bot belongs to
+type repostOwner struct {
+	SenderID     int64     `json:"sender_id"`
+	SenderChatID int64     `json:"sender_chat_id,omitempty"` // Set for messages sent on behalf of a chat
+	Caption      bool      `json:"caption"`                  // The repost is media, edits replace its caption
+	PostedAt     time.Time `json:"posted_at"`
+}
+
+// repostStore persists the owners of reposts keyed by chat and message ID
+type repostStore interface {
+	Load(chatID int64, messageID int) (owner repostOwner, found bool, err error)
+	Save(chatID int64, messageID int, owner repostOwner) error
+	Delete(chatID int64, messageID int) error
+}
+
+// repostOwners is opened in main
+var repostOwners repostStore
+
+// jsonRepostStore keeps the owners of recent reposts in memory and writes them to a JSON file on every change
+type jsonRepostStore struct {
+	mu     sync.Mutex
+	path   string
+	owners map[string]repostOwner // Keyed by "<chat ID>:<message ID>"
+}
+
+func openJSONRepostStore(path string) (*jsonRepostStore, error) {
+	store := &jsonRepostStore{path: path, owners: make(map[string]repostOwner)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read repost file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &store.owners); err != nil {
+		return nil, fmt.Errorf("failed to parse repost file %s: %w", path, err)
+	}
+	return store, nil
+}
+
+func repostKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d:%d", chatID, messageID)
+}
+
+func (s *jsonRepostStore) Load(chatID int64, messageID int) (repostOwner, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	owner, found := s.owners[repostKey(chatID, messageID)]
+	if found && time.Since(owner.PostedAt) > repostOwnerTTL {
+		return repostOwner{}, false, nil
+	}
+	return owner, found, nil
+}
+
+func (s *jsonRepostStore) Save(chatID int64, messageID int, owner repostOwner) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dropExpired(s.owners, repostOwnerTTL, func(owner repostOwner) time.Time { return owner.PostedAt })
+	s.owners[repostKey(chatID, messageID)] = owner
+	return writeJSONFile(s.path, s.owners)
+}
+
+func (s *jsonRepostStore) Delete(chatID int64, messageID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := repostKey(chatID, messageID)
+	if _, found := s.owners[key]; !found {
+		return nil
+	}
+	delete(s.owners, key)
+	return writeJSONFile(s.path, s.owners)
+}
+
+// offerDelete adds a 🗑 button to a repost, which only its sender can use
+func offerDelete(sendOpts *tele.SendOptions) {
+	appendControlButton(sendOpts, tele.InlineButton{Unique: deleteCallbackUnique, Text: "🗑"})
+}
+
+// recordRepost remembers that repost belongs to the sender of original
+func recordRepost(repost, original *tele.Message) {
+	owner := repostOwner{Caption: original.Media() != nil, PostedAt: time.Now()}
+	if original.SenderChat != nil {
+		owner.SenderChatID = original.SenderChat.ID
+	} else if original.Sender != nil {
+		owner.SenderID = original.Sender.ID
+	}
+	if err := repostOwners.Save(repost.Chat.ID, repost.ID, owner); err != nil {
+		log.Printf("Warning: Failed to save the owner of repost %d in chat %d: %v", repost.ID, repost.Chat.ID, err)
+	}
+}
+
+// ownedBy reports whether m was sent by the owner of the repost, as the same user or on behalf of the same chat
+func (o repostOwner) ownedBy(m *tele.Message) bool {
+	if o.SenderChatID != 0 || m.SenderChat != nil {
+		return m.SenderChat != nil && m.SenderChat.ID == o.SenderChatID
+	}
+	return m.Sender != nil && m.Sender.ID == o.SenderID
+}
+
+func findRepostOwner(repost *tele.Message) (repostOwner, bool) {
+	owner, found, err := repostOwners.Load(repost.Chat.ID, repost.ID)
+	if err != nil {
+		log.Printf("Failed to look up the owner of repost %d in chat %d: %v", repost.ID, repost.Chat.ID, err)
+		return repostOwner{}, false
+	}
+	return owner, found
+}
+
+// handleDeleteCallback deletes a repost on behalf of its original sender
+func handleDeleteCallback(c tele.Context, b *tele.Bot) error {
+	repost := c.Message()
+	if repost == nil { // The repost is too old to be accessible
+		return c.Respond()
+	}
+	lang := languageFor(repost.Chat.ID, c.Sender())
+
+	owner, found := findRepostOwner(repost)
+	if !found {
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "repost.unknown"), ShowAlert: true})
+	}
+	if owner.SenderChatID != 0 || c.Sender().ID != owner.SenderID { // Presses always come from a user, never from a chat
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "repost.not_owner"), ShowAlert: true})
+	}
+
+	if err := b.Delete(repost); err != nil {
+		log.Printf("Failed to delete repost (ID: %d, ChatID: %d): %v", repost.ID, repost.Chat.ID, err)
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "repost.delete_failed"), ShowAlert: true})
+	}
+	if err := repostOwners.Delete(repost.Chat.ID, repost.ID); err != nil {
+		log.Printf("Warning: Failed to forget repost %d in chat %d: %v", repost.ID, repost.Chat.ID, err)
+	}
+	return c.Respond()
+}
+
+// handleEditCommand replaces the text of the replied-to repost with the sanitized text after /edit
+func handleEditCommand(c tele.Context, b *tele.Bot) error {
+	m := c.Message()
+	lang := languageFor(c.Chat().ID, c.Sender())
+	repost := m.ReplyTo
+	text, entities := commandPayload(m)
+	if repost == nil || repost.Sender == nil || repost.Sender.ID != b.Me.ID || strings.TrimSpace(text) == "" {
+		return c.Reply(tr(lang, "edit.usage"))
+	}
+
+	owner, found := findRepostOwner(repost)
+	if !found {
+		return c.Reply(tr(lang, "repost.unknown"))
+	}
+	if !owner.ownedBy(m) {
+		return c.Reply(tr(lang, "repost.not_owner"))
+	}
+
+	settings := settingsFor(m.Chat.ID)
+	result := sanitizeURL(text, entities, settings, skipPhotos)
+	newText, newEntities := attributeMessage(m, result)
+
+	editOpts := &tele.SendOptions{Entities: newEntities, ReplyMarkup: &tele.ReplyMarkup{}}
+	if len(result.OriginalURLs) > 0 && settings.OriginalLinkButtons {
+		editOpts.ReplyMarkup.InlineKeyboard = createURLButtons(result.OriginalURLs, lang)
+	}
+	if repost.ReplyMarkup != nil { // Keep the Undo and 🗑 buttons, the "Original Link" ones are replaced
+		for _, row := range repost.ReplyMarkup.InlineKeyboard {
+			if len(row) > 0 && row[0].Data != "" {
+				editOpts.ReplyMarkup.InlineKeyboard = append(editOpts.ReplyMarkup.InlineKeyboard, row)
+			}
+		}
+	}
+
+	var err error
+	if owner.Caption {
+		_, err = b.EditCaption(repost, newText, editOpts)
+	} else {
+		_, err = b.Edit(repost, newText, editOpts)
+	}
+	if err != nil {
+		log.Printf("Failed to edit repost (ID: %d, ChatID: %d): %v", repost.ID, repost.Chat.ID, err)
+		return c.Reply(tr(lang, "edit.failed"))
+	}
+
+	if canDeleteMessages(b, m.Chat) { // The command repeats the new text, the edited repost is enough
+		deleteOriginal(b, m)
+	}
+	return nil
+}
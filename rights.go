@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// botMembers caches the bot's own membership per chat. Telegram reports every change of it
+// as a my_chat_member update, so entries stay valid until handleMyChatMember replaces them.
+var botMembers = struct {
+	sync.Mutex
+	byChat map[int64]*tele.ChatMember
+}{byChat: make(map[int64]*tele.ChatMember)}
+
+func botMember(b *tele.Bot, chat *tele.Chat) (*tele.ChatMember, error) {
+	botMembers.Lock()
+	member, ok := botMembers.byChat[chat.ID]
+	botMembers.Unlock()
+	if ok {
+		return member, nil
+	}
+
+	member, err := b.ChatMemberOf(chat, b.Me)
+	if err != nil {
+		return nil, err
+	}
+	botMembers.Lock()
+	botMembers.byChat[chat.ID] = member
+	botMembers.Unlock()
+	return member, nil
+}
+
+// canDeleteMessages reports whether the bot may delete other users' messages in chat
+func canDeleteMessages(b *tele.Bot, chat *tele.Chat) bool {
+	if chat.Type == tele.ChatPrivate {
+		return true
+	}
+	member, err := botMember(b, chat)
+	if err != nil {
+		log.Printf("Failed to look up own rights in chat %d: %v", chat.ID, err)
+		return false
+	}
+	return member.Role == tele.Creator || (member.Role == tele.Administrator && member.CanDeleteMessages)
+}
+
+// suggestOnly reports whether the bot should reply with cleaned links instead of reposting. Reposting
+// without being able to delete the original would leave every link in the chat twice.
+func suggestOnly(b *tele.Bot, chat *tele.Chat, settings ChatSettings) bool {
+	return settings.SuggestOnly || (settings.DeleteOriginal && !canDeleteMessages(b, chat))
+}
+
+func handleMyChatMember(c tele.Context) error {
+	update := c.ChatMember()
+	if update == nil || update.NewChatMember == nil {
+		return nil
+	}
+
+	botMembers.Lock()
+	defer botMembers.Unlock()
+	switch update.NewChatMember.Role {
+	case tele.Left, tele.Kicked:
+		delete(botMembers.byChat, update.Chat.ID)
+	default:
+		botMembers.byChat[update.Chat.ID] = update.NewChatMember
+	}
+	return nil
+}
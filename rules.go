@@ -14,6 +14,43 @@ var DomainRules = map[string][]string{
 	"tiktok":         {"_r", "_t"},
 }
 
+// HostRules maps mobile hosts to their canonical desktop hosts. A key also matches its subdomains,
+// so "m.wikipedia.org" turns "en.m.wikipedia.org" into "en.wikipedia.org".
+var HostRules = map[string]string{
+	"m.wikipedia.org":    "wikipedia.org",
+	"m.wiktionary.org":   "wiktionary.org",
+	"m.wikimedia.org":    "wikimedia.org",
+	"mobile.twitter.com": "twitter.com",
+	"mobile.x.com":       "x.com",
+	"m.facebook.com":     "www.facebook.com",
+	"m.youtube.com":      "www.youtube.com",
+	"m.imdb.com":         "www.imdb.com",
+	"amp.reddit.com":     "www.reddit.com",
+	"i.reddit.com":       "www.reddit.com",
+	"m.twitch.tv":        "www.twitch.tv",
+	"m.soundcloud.com":   "soundcloud.com",
+	"m.tiktok.com":       "www.tiktok.com",
+	"m.vk.com":           "vk.com",
+	"m.ebay.com":         "www.ebay.com",
+	"m.ebay.de":          "www.ebay.de",
+	"m.aliexpress.com":   "www.aliexpress.com",
+}
+
+// HSTSRules contains hosts that only serve HTTPS, their http:// links are upgraded when HTTPS_UPGRADE is on.
+// A "*." prefix includes all subdomains.
+var HSTSRules = []string{
+	"*.amazon.com",
+	"*.amazon.de",
+	"*.amazon.co.uk",
+	"*.aliexpress.com",
+	"*.spotify.com",
+	"*.x.com",
+	"*.fixupx.com",
+	"*.fxtwitter.com",
+	"vm.dstn.to",
+	"eeinstagram.com",
+}
+
 // URLRules contains a list of query parameter patterns that should be removed from URLs
 var URLRules = []string{
 	"action_object_map",
@@ -158,3 +195,37 @@ var URLRules = []string{
 	"share_id@reddit.com",
 	"si@soundcloud.com",
 }
+
+// PathRules contains domain-specific path segment prefixes that carry tracking data (e.g. Amazon's /ref=sr_1_1)
+var PathRules = map[string][]string{
+	"amazon":        {"ref="},
+	"audible":       {"ref="},
+	"goodreads.com": {"ref="},
+}
+
+// MatrixRules contains matrix parameter names (";key=value" inside a path segment) that should be removed from URLs.
+// Names match the whole key, case-insensitively.
+var MatrixRules = []string{
+	"jsessionid",
+	"phpsessid",
+	"sessionid",
+	"sid",
+	"cfid",
+	"cftoken",
+}
+
+// FragmentRules contains key patterns for "key=value" pairs in the URL fragment that should be removed
+var FragmentRules = []string{
+	"utm_",
+	"xtor",
+	"at_medium",
+	"at_campaign",
+	"at_custom",
+	"fbclid",
+	"gclid",
+	"mc_cid",
+	"mc_eid",
+	"mkt_tok",
+	"_hsenc",
+	"_hsmi",
+}
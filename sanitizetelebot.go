@@ -1,17 +1,19 @@
 package main
 
 import (
-	"bufio"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -39,7 +41,12 @@ var httpClient = &http.Client{
 // Constants for various strings and configurations
 const (
 	telegramTokenEnvVar = "TELEGRAM_BOT_TOKEN"
+	httpsUpgradeEnvVar  = "HTTPS_UPGRADE"
+	settingsFileEnvVar  = "SETTINGS_FILE"
+	repostsFileEnvVar   = "REPOSTS_FILE"
 	tokenFileName       = "token.txt"
+	settingsFileName    = "settings.json" // Default location of the per-chat settings
+	repostsFileName     = "reposts.json"  // Default location of the owners of recent reposts
 	imageCacheDir       = "image_cache"
 
 	tiktokShortHost        = "vm.tiktok.com"
@@ -59,17 +66,40 @@ const (
 	instagramPostPathSegment    = "/p/"
 	ddInstagramHost             = "eeinstagram.com"
 
+	textFragmentDirective = ":~:"      // Scroll-to-text fragments: #:~:text=...
+	defaultURLScheme      = "https://" // Assumed for links typed without a scheme
+
 	msgMarkerAnon        = "anon"
 	msgMarkerNoCut       = "nocut"
 	inlineQueryDefaultID = "clearurl_result_1" // More specific ID
+	inlineQueryExplainID = "clearurl_explain_1"
 )
 
-// markdownEscaper is a reusable strings.Replacer for escaping Markdown characters.
-var markdownEscaper = strings.NewReplacer(
-	"[", "\\[", "]", "\\]",
-	"_", "\\_", "*", "\\*",
-	"`", "\\`",
-)
+// httpsUpgradeEnabled controls whether http:// links to HSTS hosts are rewritten to https://
+var httpsUpgradeEnabled bool
+
+// sanitizeResult holds the outcome of sanitizeURL
+type sanitizeResult struct {
+	Text               string        // Message text with cleaned links spliced in
+	Entities           tele.Entities // Message entities remapped onto Text, text_link URLs cleaned
+	Sanitized          bool          // Whether any link changed or a TikTok album is due
+	IsTikTokPhotoAlbum bool
+	PhotoPaths         []string     // Downloaded TikTok photos, to be removed after sending
+	OriginalURLs       []string     // Links as sent by the user, for the "Original Link" buttons
+	Links              []linkReport // What happened to each link, for /explain
+}
+
+// captionedMedia attaches caption entities to an album item, which telebot's media types don't do on their own
+type captionedMedia struct {
+	tele.Inputtable
+	entities tele.Entities
+}
+
+func (cm captionedMedia) InputMedia() tele.InputMedia {
+	inputMedia := cm.Inputtable.InputMedia()
+	inputMedia.Entities = cm.entities
+	return inputMedia
+}
 
 func main() {
 	tokenStr := loadTelegramToken()
@@ -77,6 +107,28 @@ func main() {
 		log.Fatal("Error: Telegram bot token is empty or could not be loaded. Please provide a valid token via TELEGRAM_BOT_TOKEN env var or token.txt file.")
 	}
 
+	if enabled, err := strconv.ParseBool(os.Getenv(httpsUpgradeEnvVar)); err == nil {
+		httpsUpgradeEnabled = enabled
+	}
+
+	settingsPath := os.Getenv(settingsFileEnvVar)
+	if settingsPath == "" {
+		settingsPath = settingsFileName
+	}
+	store, err := openJSONSettingsStore(settingsPath)
+	if err != nil {
+		log.Fatalf("Failed to open settings store: %v", err)
+	}
+	chatSettingsStore = store
+
+	repostsPath := os.Getenv(repostsFileEnvVar)
+	if repostsPath == "" {
+		repostsPath = repostsFileName
+	}
+	if repostOwners, err = openJSONRepostStore(repostsPath); err != nil {
+		log.Fatalf("Failed to open repost store: %v", err)
+	}
+
 	pref := tele.Settings{
 		Token:  tokenStr,
 		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
@@ -91,10 +143,73 @@ func main() {
 		return handleTextMessage(c, b)
 	})
 
+	for _, mediaEndpoint := range []string{tele.OnPhoto, tele.OnVideo, tele.OnDocument, tele.OnAnimation, tele.OnAudio} {
+		b.Handle(mediaEndpoint, func(c tele.Context) error {
+			return handleMediaMessage(c, b)
+		})
+	}
+
+	b.Handle(tele.OnEdited, func(c tele.Context) error {
+		return handleEditedMessage(c, b)
+	})
+
+	b.Handle(tele.OnChannelPost, func(c tele.Context) error {
+		return handleChannelPost(c, b)
+	})
+
+	b.Handle(tele.OnEditedChannelPost, func(c tele.Context) error {
+		return handleEditedChannelPost(c, b)
+	})
+
+	b.Handle(tele.OnMigration, handleMigration)
+	b.Handle(tele.OnMyChatMember, handleMyChatMember)
+
+	b.Handle("/start", handleStartCommand)
+	b.Handle("/help", handleHelpCommand)
+	b.Handle("/about", handleAboutCommand)
+	b.Handle("/clean", func(c tele.Context) error {
+		return handleCleanCommand(c, b)
+	})
+	b.Handle("/explain", handleExplainCommand)
+
+	b.Handle("/edits", func(c tele.Context) error {
+		return handleEditsCommand(c, b)
+	})
+
+	b.Handle("/attribution", func(c tele.Context) error {
+		return handleAttributionCommand(c, b)
+	})
+
+	b.Handle("/language", func(c tele.Context) error {
+		return handleLanguageCommand(c, b)
+	})
+
+	b.Handle("/settings", func(c tele.Context) error {
+		return handleSettingsCommand(c, b)
+	})
+
+	b.Handle(&tele.InlineButton{Unique: undoCallbackUnique}, func(c tele.Context) error {
+		return handleUndoCallback(c, b)
+	})
+
+	b.Handle(&tele.InlineButton{Unique: deleteCallbackUnique}, func(c tele.Context) error {
+		return handleDeleteCallback(c, b)
+	})
+
+	b.Handle("/edit", func(c tele.Context) error {
+		return handleEditCommand(c, b)
+	})
+
+	b.Handle(&tele.InlineButton{Unique: settingsCallbackUnique}, func(c tele.Context) error {
+		return handleSettingsCallback(c, b)
+	})
+
 	b.Handle(tele.OnQuery, func(c tele.Context) error {
 		return handleInlineQuery(c, b)
 	})
 
+	registerCommands(b)
+
 	log.Println("Bot is starting...")
 	b.Start()
 }
@@ -128,47 +243,38 @@ func handleTextMessage(c tele.Context, b *tele.Bot) error {
 		log.Println("Warning: Received message without sender information.")
 		return nil // Or handle as an error by returning an error
 	}
-	username := getUsername(sender)
+	if isCommand(c.Message()) {
+		return nil // Commands, including ones meant for other bots, are never reposted
+	}
+	if c.Message().AutomaticForward {
+		return nil // handleChannelPost cleans the channel post, reposting its copy would cut it off from its comments
+	}
 	messageText := c.Text()
 
-	if strings.Contains(messageText, msgMarkerNoCut) {
+	settings := settingsFor(c.Chat().ID)
+	if hasMarker(messageText, settings.NoCutMarker) {
 		return nil // "nocut" keyword present, do nothing.
 	}
 
-	sanitizedMsg, wasSanitized, isTikTokPhotoAlbum, downloadedPhotoPaths, originalURLs, err := sanitizeURL(messageText)
-	if err != nil {
-		log.Printf("Error sanitizing URL for text from user %s ('%s'): %v", username, messageText, err)
-		// Notify user about the error, optionally.
-		// c.Reply("Sorry, I couldn't process your message due to an internal error.")
-		return err // Propagate error to telebot library, it might log it or handle it.
-	}
-
-	if !wasSanitized {
+	result := sanitizeURL(messageText, c.Message().Entities, settings, fetchPhotos)
+	if !result.Sanitized {
 		return nil // No URLs were changed or special actions taken.
 	}
-
-	sendOpts := &tele.SendOptions{ParseMode: tele.ModeMarkdown}
-	if c.Message().IsReply() && c.Message().ReplyTo != nil {
-		sendOpts.ReplyTo = c.Message().ReplyTo
+	if suggestOnly(b, c.Chat(), settings) {
+		return sendSuggestion(b, c.Message(), result)
 	}
+	downloadedPhotoPaths := result.PhotoPaths
 
-	if len(originalURLs) > 0 {
-		buttons := createURLButtons(originalURLs)
-		sendOpts.ReplyMarkup = &tele.ReplyMarkup{InlineKeyboard: buttons}
-	}
+	sendOpts := repostOptions(c.Message(), result)
+	messageToSend, messageEntities := attributeMessage(c.Message(), result)
 
 	var sendErr error
-	if isTikTokPhotoAlbum && len(downloadedPhotoPaths) > 0 {
+	if result.IsTikTokPhotoAlbum && len(downloadedPhotoPaths) > 0 {
 		// Define the maximum number of photos per message
 		const maxPhotosPerMessage = 10
 
-		// Prepare the base caption text
-		var baseCaption string
-		if c.Message().FromGroup() && strings.Contains(sanitizedMsg, msgMarkerAnon) {
-			baseCaption = strings.Replace(sanitizedMsg, msgMarkerAnon, "", 1)
-		} else {
-			baseCaption = "@" + username + " said: " + sanitizedMsg
-		}
+		baseCaption := messageToSend
+		lang := languageFor(c.Chat().ID, sender)
 
 		// Calculate total number of parts
 		totalParts := (len(downloadedPhotoPaths) + maxPhotosPerMessage - 1) / maxPhotosPerMessage
@@ -184,17 +290,19 @@ func handleTextMessage(c tele.Context, b *tele.Bot) error {
 			album := make(tele.Album, 0, maxPhotosPerMessage)
 			for j, photoPath := range downloadedPhotoPaths[i:end] {
 				photo := &tele.Photo{File: tele.FromDisk(photoPath)}
+				var captionEntities tele.Entities
 				if j == 0 { // Add caption to first photo of each album
 					partNum := (i / maxPhotosPerMessage) + 1
 					captionText := baseCaption
 					if partNum > 1 { // Add part number for all parts except the first
-						captionText = fmt.Sprintf("%s (Part %d/%d)", baseCaption, partNum, totalParts)
+						captionText = tr(lang, "album.part", baseCaption, partNum, totalParts)
 					} else if totalParts > 1 { // For first part, only add number if there are multiple parts
-						captionText = fmt.Sprintf("%s (Part 1/%d)", baseCaption, totalParts)
+						captionText = tr(lang, "album.part", baseCaption, 1, totalParts)
 					}
-					photo.Caption = escapeMarkdown(captionText)
+					photo.Caption = captionText
+					captionEntities = messageEntities // Part numbers are appended, so offsets stay valid
 				}
-				album = append(album, photo)
+				album = append(album, captionedMedia{Inputtable: photo, entities: captionEntities})
 			}
 
 			// Send this batch
@@ -206,19 +314,17 @@ func handleTextMessage(c tele.Context, b *tele.Bot) error {
 		}
 
 		// Clean up downloaded images after attempting to send all batches
-		for _, photoPath := range downloadedPhotoPaths {
-			if rmErr := os.Remove(photoPath); rmErr != nil {
-				log.Printf("Failed to remove cached image %s: %v", photoPath, rmErr)
-			}
-		}
+		removeCachedImages(downloadedPhotoPaths)
 	} else {
-		var messageToSend string
-		if c.Message().FromGroup() && strings.Contains(sanitizedMsg, msgMarkerAnon) { // Check original sanitizedMsg for "anon"
-			messageToSend = strings.Replace(sanitizedMsg, msgMarkerAnon, "", 1)
-		} else {
-			messageToSend = "@" + username + " said: " + sanitizedMsg
+		sendOpts.Entities = messageEntities
+		if settings.DeleteOriginal {
+			offerUndo(sendOpts, c.Message())
+		}
+		offerDelete(sendOpts)
+		var repost *tele.Message
+		if repost, sendErr = sendText(b, c.Chat(), messageToSend, sendOpts, repostReply(c.Message())); sendErr == nil {
+			recordRepost(repost, c.Message())
 		}
-		_, sendErr = b.Send(c.Chat(), escapeMarkdown(messageToSend), sendOpts)
 	}
 
 	if sendErr != nil {
@@ -227,30 +333,97 @@ func handleTextMessage(c tele.Context, b *tele.Bot) error {
 	}
 
 	// Successfully sent the new message, now delete the original.
-	if err := b.Delete(c.Message()); err != nil {
-		log.Printf("Failed to delete original message (ID: %d, ChatID: %d): %v", c.Message().ID, c.Chat().ID, err)
-		// Not returning this error as critical because the main operation (sending sanitized message) succeeded.
+	markHandled(c.Message())
+	if settings.DeleteOriginal {
+		deleteOriginal(b, c.Message())
 	}
 	return nil
 }
 
-func handleInlineQuery(c tele.Context, b *tele.Bot) error {
-	queryText := c.Query().Text
-	sanitizedMsg, wasSanitized, _, _, _, err := sanitizeURL(queryText)
-	if err != nil {
-		log.Printf("Error sanitizing URL for inline query '%s': %v", queryText, err)
-		return err
+// repostOptions builds the send options shared by all reposts: forum topic, reply target and "Original Link" buttons.
+// Quotes and replies to other chats need repostReply on top.
+func repostOptions(m *tele.Message, result sanitizeResult) *tele.SendOptions {
+	sendOpts := &tele.SendOptions{}
+	if m.TopicMessage { // Stay in the forum topic the user posted in
+		sendOpts.ThreadID = m.ThreadID
+	}
+	if m.ReplyTo != nil && m.ReplyTo.TopicCreated == nil { // Topic messages "reply" to the topic's first message
+		sendOpts.ReplyTo = m.ReplyTo
+	}
+
+	if len(result.OriginalURLs) > 0 && settingsFor(m.Chat.ID).OriginalLinkButtons {
+		buttons := createURLButtons(result.OriginalURLs, languageFor(m.Chat.ID, m.Sender))
+		sendOpts.ReplyMarkup = &tele.ReplyMarkup{InlineKeyboard: buttons}
+	}
+	return sendOpts
+}
+
+// attributeMessage prepares the repost text, "anon" in groups drops the attribution of the sender.
+// Forwarded messages keep their origin. The user's formatting is kept by sending the remapped
+// entities instead of a parse mode.
+func attributeMessage(m *tele.Message, result sanitizeResult) (string, tele.Entities) {
+	settings := settingsFor(m.Chat.ID)
+	anonymous := m.FromGroup() && hasMarker(result.Text, settings.AnonMarker)
+	text, entities := result.Text, result.Entities
+	if anonymous {
+		text, entities = cutText(text, entities, settings.AnonMarker)
+	}
+
+	var prefix string
+	var prefixEntities tele.Entities
+	template := settings.AttributionTemplate
+	lang := languageFor(m.Chat.ID, m.Sender)
+	switch {
+	case !anonymous && template != "":
+		prefix, prefixEntities = renderAttribution(template, m, lang)
+		prefix += " "
+	case m.Origin != nil: // Without a sender the forward origin is still credited
+		prefix, prefixEntities = forwardAttribution(m.Origin, lang, "attribution.forwarded_standalone")
+		prefix += " "
+	}
+
+	text, entities = prependText(prefix, text, entities)
+	return text, append(prefixEntities, entities...)
+}
+
+func deleteOriginal(b *tele.Bot, m *tele.Message) {
+	if err := b.Delete(m); err != nil {
+		log.Printf("Failed to delete original message (ID: %d, ChatID: %d): %v", m.ID, m.Chat.ID, err)
+		// Not returning this error as critical because the main operation (sending sanitized message) succeeded.
+	}
+}
+
+func removeCachedImages(photoPaths []string) {
+	for _, photoPath := range photoPaths {
+		if rmErr := os.Remove(photoPath); rmErr != nil {
+			log.Printf("Failed to remove cached image %s: %v", photoPath, rmErr)
+		}
 	}
+}
 
-	if wasSanitized {
+func handleInlineQuery(c tele.Context, b *tele.Bot) error {
+	queryText := c.Query().Text
+	sanitized := sanitizeURL(queryText, nil, settingsFor(c.Sender().ID), skipPhotos) // Inline queries carry no entities and no chat, the user's private chat settings apply
+	if sanitized.Sanitized {
+		lang := languageFor(c.Sender().ID, c.Sender())
 		result := &tele.ArticleResult{
-			Title:       "Sanitized URL",                // Could be more dynamic, e.g., show the cleaned URL snippet
-			Text:        sanitizedMsg,                   // This is MessageText, which is sent when user selects the result
-			Description: "Tap to send the cleaned URL.", // Shown in the results list
+			Title:       tr(lang, "inline.title"),       // Could be more dynamic, e.g., show the cleaned URL snippet
+			Text:        sanitized.Text,                 // This is MessageText, which is sent when user selects the result
+			Description: tr(lang, "inline.description"), // Shown in the results list
 		}
 		result.SetResultID(inlineQueryDefaultID) // ID should be unique if you plan to have multiple results
 
-		results := []tele.Result{result}
+		explanation := &tele.ArticleResult{
+			Title:       tr(lang, "inline.explain_title"),
+			Description: tr(lang, "inline.explain_description"),
+		}
+		explanation.Content = &tele.InputTextMessageContent{ // Article results can't carry entities, so the diff block is sent as HTML
+			Text:      `<pre><code class="language-diff">` + html.EscapeString(explainLinks(sanitized.Links, lang)) + "</code></pre>",
+			ParseMode: tele.ModeHTML,
+		}
+		explanation.SetResultID(inlineQueryExplainID)
+
+		results := []tele.Result{result, explanation}
 		resp := &tele.QueryResponse{
 			Results:   results,
 			CacheTime: 60, // Optional: How long (in seconds) the Telegram client should cache this result.
@@ -265,211 +438,413 @@ func handleInlineQuery(c tele.Context, b *tele.Bot) error {
 	return nil
 }
 
-func getUsername(sender *tele.User) string {
-	if sender.Username != "" {
-		return sender.Username
-	}
-	return sender.FirstName // Fallback to FirstName if username is not set
-}
-
-func sanitizeURL(text string) (sanitizedText string, wasSanitized bool, isTikTokPhotoAlbum bool, downloadedPhotoPaths []string, originalURLs []string, err error) {
-	var sb strings.Builder
-	sb.Grow(len(text) + 64) // Pre-allocate: original length + buffer for prefixes/changes
+// photoFetch tells sanitizeURL whether TikTok photo posts may be downloaded to be reposted as an album.
+// Only new text messages are reposted that way, everything else just cleans the link.
+type photoFetch bool
 
-	scanner := bufio.NewScanner(strings.NewReader(text))
-	isFirstParagraph := true
+const (
+	fetchPhotos photoFetch = true
+	skipPhotos  photoFetch = false
+)
 
-	for scanner.Scan() {
-		paragraph := scanner.Text()
-		if !isFirstParagraph {
-			sb.WriteByte('\n')
+func sanitizeURL(text string, entities tele.Entities, settings ChatSettings, photos photoFetch) (result sanitizeResult) {
+	var sb strings.Builder
+	sb.Grow(len(text) + 64) // Pre-allocate: original length + buffer for changes
+
+	var edits []textEdit
+	last := 0
+	for _, span := range findLinks(text, entities) {
+		sb.WriteString(text[last:span.start]) // Copy everything between links unchanged
+		last = span.end
+
+		link := text[span.start:span.end]
+		rawURL, ok := absoluteURL(link)
+		if !ok {
+			sb.WriteString(link) // Other schemes (ftp://, ...) are left alone
+			continue
 		}
-		isFirstParagraph = false
+		result.OriginalURLs = append(result.OriginalURLs, rawURL)
 
-		if strings.TrimSpace(paragraph) == "" { // Preserve empty lines by only writing \n if it's not the first
-			if !isFirstParagraph { // if it was truly an empty line after a non-empty one
-				// sb.WriteByte('\n') // Already handled by the start of loop logic
-			}
+		processedURL, linkSanitized, photoPaths, changes := sanitizeLink(rawURL, settings, photos)
+		result.Links = append(result.Links, linkReport{Original: rawURL, Cleaned: processedURL, Changes: changes})
+		if !linkSanitized {
+			sb.WriteString(link) // Keep the link exactly as the user typed it
 			continue
 		}
+		if rawURL != link { // Don't force a scheme onto links that were typed without one
+			processedURL = strings.TrimPrefix(processedURL, defaultURLScheme)
+		}
+		sb.WriteString(processedURL)
+		edits = append(edits, textEdit{start: utf16Len(text[:span.start]), end: utf16Len(text[:span.end]), newLength: utf16Len(processedURL)})
+		result.Sanitized = true
+		result.PhotoPaths = append(result.PhotoPaths, photoPaths...)
+	}
+	sb.WriteString(text[last:])
+	result.Text = sb.String()
+	result.Entities = remapEntities(entities, edits)
+
+	// --- Hidden text_link URLs (anchor text stays, only the target changes) ---
+	codeSpans := entitySpans(result.Text, result.Entities, tele.EntityCode, tele.EntityCodeBlock)
+	for i, entity := range result.Entities {
+		if entity.Type != tele.EntityTextLink {
+			continue
+		}
+		anchor := linkSpan{start: byteOffset(result.Text, entity.Offset), end: byteOffset(result.Text, entity.Offset+entity.Length)}
+		if slices.ContainsFunc(codeSpans, anchor.overlaps) {
+			continue // Links inside code and pre entities are left alone
+		}
+		result.OriginalURLs = append(result.OriginalURLs, entity.URL)
+		processedURL, linkSanitized, photoPaths, changes := sanitizeLink(entity.URL, settings, photos)
+		result.Links = append(result.Links, linkReport{Original: entity.URL, Cleaned: processedURL, Changes: changes})
+		if linkSanitized {
+			result.Entities[i].URL = processedURL
+			result.Sanitized = true
+			result.PhotoPaths = append(result.PhotoPaths, photoPaths...)
+		}
+	}
 
-		words := strings.Fields(paragraph)
-		isFirstWordInParagraph := true
+	// If photos were actually downloaded for a TikTok photo post,
+	// then it counts as an album (because an action is taken).
+	result.IsTikTokPhotoAlbum = len(result.PhotoPaths) > 0
+	return result
+}
 
-		for _, word := range words {
-			if !isFirstWordInParagraph {
-				sb.WriteByte(' ')
-			}
-			isFirstWordInParagraph = false
+// sanitizeLink cleans a single absolute URL. For TikTok photo posts it also returns the downloaded photos.
+// Frontend rewrites and photo downloads only happen if the chat's settings allow them, downloads also
+// need photos to be fetchPhotos. Every step that changed the link is recorded in changes.
+func sanitizeLink(rawURL string, settings ChatSettings, photos photoFetch) (processedURL string, sanitized bool, photoPaths []string, changes []linkChange) {
+	processedURL = rawURL
 
-			if !containsURL(word) {
-				sb.WriteString(word)
-				continue
-			}
+	parsedURL, parseErr := url.Parse(rawURL)
+	if parseErr != nil {
+		log.Printf("Warning: Failed to parse URL '%s': %v. Using original.", rawURL, parseErr)
+		return rawURL, false, nil, nil
+	}
 
-			originalURLs = append(originalURLs, word)
-			currentWordSanitized := false
-			processedWord := word
+	// --- Mobile to Desktop Host Normalization ---
+	if change, ok := normalizeHost(parsedURL); ok {
+		processedURL = parsedURL.String()
+		sanitized = true
+		changes = append(changes, change)
+	}
 
-			parsedURL, parseErr := url.Parse(word)
-			if parseErr != nil {
-				log.Printf("Warning: Failed to parse URL '%s': %v. Using original.", word, parseErr)
-				sb.WriteString(word)
-				continue
-			}
+	// --- HTTPS Upgrade for HSTS Hosts ---
+	if httpsUpgradeEnabled && upgradeToHTTPS(parsedURL) {
+		processedURL = parsedURL.String()
+		sanitized = true
+		changes = append(changes, linkChange{Kind: changeHTTPSUpgrade, Rule: "HSTS", From: "http", To: "https"})
+	}
 
-			// --- TikTok URL Expansion ---
-			if parsedURL.Host == tiktokShortHost || parsedURL.Host == tiktokProHost || parsedURL.Host == tiktokHost {
-				expandedURLStr, expandErr := ExpandUrl(parsedURL.String()) // Uses global httpClient
-				if expandErr != nil {
-					log.Printf("Warning: Failed to expand TikTok URL '%s': %v. Proceeding with unexpanded.", parsedURL.String(), expandErr)
-				} else {
-					expandedParsedURL, parseExpandedErr := url.Parse(expandedURLStr)
-					if parseExpandedErr != nil {
-						log.Printf("Warning: Failed to parse expanded TikTok URL '%s': %v. Proceeding with unexpanded original.", expandedURLStr, parseExpandedErr)
-					} else {
-						if parsedURL.String() != expandedParsedURL.String() { // If expansion changed the URL
-							currentWordSanitized = true
-						}
-						parsedURL = expandedParsedURL
-						processedWord = parsedURL.String()
-					}
+	// --- TikTok URL Expansion ---
+	if parsedURL.Host == tiktokShortHost || parsedURL.Host == tiktokProHost || parsedURL.Host == tiktokHost {
+		expandedURLStr, redirects, expandErr := ExpandUrl(parsedURL.String()) // Uses global httpClient
+		if expandErr != nil {
+			log.Printf("Warning: Failed to expand TikTok URL '%s': %v. Proceeding with unexpanded.", parsedURL.String(), expandErr)
+		} else {
+			expandedParsedURL, parseExpandedErr := url.Parse(expandedURLStr)
+			if parseExpandedErr != nil {
+				log.Printf("Warning: Failed to parse expanded TikTok URL '%s': %v. Proceeding with unexpanded original.", expandedURLStr, parseExpandedErr)
+			} else {
+				if parsedURL.String() != expandedParsedURL.String() { // If expansion changed the URL
+					sanitized = true
+					changes = append(changes, linkChange{Kind: changeExpansion, Chain: redirects})
 				}
+				parsedURL = expandedParsedURL
+				processedURL = parsedURL.String()
 			}
+		}
+	}
 
-			// --- TikTok Photo Album Processing (after potential expansion) ---
-			if strings.HasSuffix(parsedURL.Host, tiktokHostSuffix) && strings.Contains(parsedURL.Path, tiktokPhotoPathSegment) {
-				isTikTokPhotoAlbum = true                                         // Mark that this type of URL was encountered
-				tempPhotoPaths, fetchErr := fetchTikTokPhotos(parsedURL.String()) // Uses global httpClient
-				if fetchErr != nil {
-					log.Printf("Warning: Failed to fetch TikTok photos for '%s': %v. URL params will be cleaned, but no album.", parsedURL.String(), fetchErr)
-					isTikTokPhotoAlbum = false // Reset if fetching fails, it's not an album then
-				} else {
-					downloadedPhotoPaths = append(downloadedPhotoPaths, tempPhotoPaths...)
-				}
-
-				if parsedURL.RawQuery != "" { // Always remove query params for TikTok photo URLs
-					parsedURL.RawQuery = ""
-					currentWordSanitized = true
-				}
-				processedWord = parsedURL.String()
+	// --- TikTok Photo Album Processing (after potential expansion) ---
+	if strings.HasSuffix(parsedURL.Host, tiktokHostSuffix) && strings.Contains(parsedURL.Path, tiktokPhotoPathSegment) {
+		if settings.TikTokAlbums && photos == fetchPhotos {
+			tempPhotoPaths, fetchErr := fetchTikTokPhotos(parsedURL.String()) // Uses global httpClient
+			if fetchErr != nil {
+				log.Printf("Warning: Failed to fetch TikTok photos for '%s': %v. URL params will be cleaned, but no album.", parsedURL.String(), fetchErr)
 			} else {
-				// --- General Parameter Cleaning and Host Replacements (for non-TikTok photo URLs) ---
-				q := parsedURL.Query()
-				paramsModified := false
-
-				for paramName := range q { // Universal rules
-					for _, rulePrefix := range URLRules {
-						if strings.HasPrefix(paramName, rulePrefix) {
-							q.Del(paramName)
-							paramsModified = true
-						}
-					}
-				}
-				for domainKey, rulePrefixes := range DomainRules { // Domain-specific rules
-					if strings.Contains(parsedURL.Host, domainKey) { // `domainKey` could be "amazon" matching "amazon.co.uk"
-						for paramName := range q {
-							for _, rulePrefix := range rulePrefixes {
-								if strings.HasPrefix(paramName, rulePrefix) {
-									q.Del(paramName)
-									paramsModified = true
-								}
-							}
-						}
+				photoPaths = tempPhotoPaths
+			}
+		}
+
+		if parsedURL.RawQuery != "" { // Always remove query params for TikTok photo URLs
+			changes = append(changes, queryChanges(parsedURL.Query(), "TikTok photo")...)
+			parsedURL.RawQuery = ""
+			sanitized = true
+		}
+		processedURL = parsedURL.String()
+	} else {
+		// --- General Parameter Cleaning and Host Replacements (for non-TikTok photo URLs) ---
+		q := parsedURL.Query()
+		var paramChanges []linkChange
+
+		for paramName, values := range q { // Universal rules
+			if rulePrefix, ok := matchRulePrefix(paramName, URLRules); ok {
+				q.Del(paramName)
+				paramChanges = append(paramChanges, linkChange{Kind: changeQueryParam, Rule: "URLRules:" + rulePrefix, Key: paramName, Value: strings.Join(values, ",")})
+			}
+		}
+		for domainKey, rulePrefixes := range DomainRules { // Domain-specific rules
+			if strings.Contains(parsedURL.Host, domainKey) { // `domainKey` could be "amazon" matching "amazon.co.uk"
+				for paramName, values := range q {
+					if rulePrefix, ok := matchRulePrefix(paramName, rulePrefixes); ok {
+						q.Del(paramName)
+						paramChanges = append(paramChanges, linkChange{Kind: changeQueryParam, Rule: fmt.Sprintf("DomainRules[%s]:%s", domainKey, rulePrefix), Key: paramName, Value: strings.Join(values, ",")})
 					}
 				}
-				if paramsModified {
-					parsedURL.RawQuery = q.Encode()
-					processedWord = parsedURL.String()
-					currentWordSanitized = true
-				}
+			}
+		}
+		if len(paramChanges) > 0 {
+			parsedURL.RawQuery = q.Encode()
+			processedURL = parsedURL.String()
+			sanitized = true
+			slices.SortFunc(paramChanges, func(a, b linkChange) int { return strings.Compare(a.Key, b.Key) }) // Map order is random
+			changes = append(changes, paramChanges...)
+		}
 
-				// --- Special Domain Replacements ---
-				if strings.HasSuffix(parsedURL.Host, tiktokHostSuffix) { // TikTok non-photo/live
-					if !strings.Contains(parsedURL.Path, tiktokPhotoPathSegment) && !strings.Contains(parsedURL.Path, tiktokLivePathSegment) {
-						if parsedURL.Host != tiktokCleanHost && strings.Contains(parsedURL.Path, "/video/") {
-							parsedURL.Host = tiktokCleanHost
-							processedWord = parsedURL.String()
-							currentWordSanitized = true
-						}
-					}
-					if strings.Contains(parsedURL.Path, tiktokLivePathSegment) && parsedURL.RawQuery != "" { // TikTok Live
-						parsedURL.RawQuery = ""
-						processedWord = parsedURL.String()
-						currentWordSanitized = true
-					}
+		// --- Path Segment, Matrix Parameter and Fragment Cleaning ---
+		if pathChanges := stripPathTrackers(parsedURL); len(pathChanges) > 0 {
+			processedURL = parsedURL.String()
+			sanitized = true
+			changes = append(changes, pathChanges...)
+		}
+		if fragmentChanges := stripFragmentTrackers(parsedURL); len(fragmentChanges) > 0 {
+			processedURL = parsedURL.String()
+			sanitized = true
+			changes = append(changes, fragmentChanges...)
+		}
+
+		// --- Special Domain Replacements ---
+		if strings.HasSuffix(parsedURL.Host, tiktokHostSuffix) { // TikTok non-photo/live
+			if !strings.Contains(parsedURL.Path, tiktokPhotoPathSegment) && !strings.Contains(parsedURL.Path, tiktokLivePathSegment) {
+				if settings.FrontendTikTok && parsedURL.Host != tiktokCleanHost && strings.Contains(parsedURL.Path, "/video/") {
+					changes = append(changes, linkChange{Kind: changeFrontend, Rule: "frontend_tiktok", From: parsedURL.Host, To: tiktokCleanHost})
+					parsedURL.Host = tiktokCleanHost
+					processedURL = parsedURL.String()
+					sanitized = true
 				}
-				if parsedURL.Host == xComHost && parsedURL.Host != fixupXHost { // X.com
-					parsedURL.Host = fixupXHost
-					processedWord = parsedURL.String()
-					currentWordSanitized = true
+			}
+			if strings.Contains(parsedURL.Path, tiktokLivePathSegment) && parsedURL.RawQuery != "" { // TikTok Live
+				changes = append(changes, queryChanges(parsedURL.Query(), "TikTok live")...)
+				parsedURL.RawQuery = ""
+				processedURL = parsedURL.String()
+				sanitized = true
+			}
+		}
+		if settings.FrontendX && parsedURL.Host == xComHost && parsedURL.Host != fixupXHost { // X.com
+			changes = append(changes, linkChange{Kind: changeFrontend, Rule: "frontend_x", From: parsedURL.Host, To: fixupXHost})
+			parsedURL.Host = fixupXHost
+			processedURL = parsedURL.String()
+			sanitized = true
+		}
+		if strings.HasSuffix(parsedURL.Host, instagramHostSuffix) { // Instagram
+			pathSegments := strings.Split(parsedURL.Path, "/")
+			if len(pathSegments) > 2 && pathSegments[2] == instagramProfileCardSegment { // /username/profilecard/...
+				changes = append(changes, linkChange{Kind: changePathSegment, Rule: "Instagram profile card", Key: strings.Join(pathSegments[2:], "/")})
+				parsedURL.Path = "/" + pathSegments[1] // Becomes /username
+				processedURL = parsedURL.String()
+				sanitized = true
+			}
+			if settings.FrontendInstagram && (strings.Contains(parsedURL.Path, instagramReelPathSegment) || strings.Contains(parsedURL.Path, instagramPostPathSegment)) {
+				if parsedURL.Host != ddInstagramHost {
+					changes = append(changes, linkChange{Kind: changeFrontend, Rule: "frontend_instagram", From: parsedURL.Host, To: ddInstagramHost})
+					parsedURL.Host = ddInstagramHost
+					processedURL = parsedURL.String()
+					sanitized = true
 				}
-				if strings.HasSuffix(parsedURL.Host, instagramHostSuffix) { // Instagram
-					pathSegments := strings.Split(parsedURL.Path, "/")
-					if len(pathSegments) > 2 && pathSegments[2] == instagramProfileCardSegment { // /username/profilecard/...
-						parsedURL.Path = "/" + pathSegments[1] // Becomes /username
-						processedWord = parsedURL.String()
-						currentWordSanitized = true
-					}
-					if strings.Contains(parsedURL.Path, instagramReelPathSegment) || strings.Contains(parsedURL.Path, instagramPostPathSegment) {
-						if parsedURL.Host != ddInstagramHost {
-							parsedURL.Host = ddInstagramHost
-							processedWord = parsedURL.String()
-							currentWordSanitized = true
-						}
-					}
+			}
+		}
+	}
+	if len(photoPaths) > 0 { // Sending the photos as an album counts as sanitizing
+		sanitized = true
+		changes = append(changes, linkChange{Kind: changeTikTokAlbum})
+	}
+	return processedURL, sanitized, photoPaths, changes
+}
+
+// queryChanges records the removal of the whole query, sorted by parameter name
+func queryChanges(q url.Values, rule string) []linkChange {
+	changes := make([]linkChange, 0, len(q))
+	for paramName, values := range q {
+		changes = append(changes, linkChange{Kind: changeQueryParam, Rule: rule, Key: paramName, Value: strings.Join(values, ",")})
+	}
+	slices.SortFunc(changes, func(a, b linkChange) int { return strings.Compare(a.Key, b.Key) })
+	return changes
+}
+
+func containsURL(text string) bool {
+	scheme, _, found := strings.Cut(text, "://")
+	return found && (strings.EqualFold(scheme, "http") || strings.EqualFold(scheme, "https"))
+}
+
+// absoluteURL returns link with a scheme, links typed without one ("youtu.be/abc?si=x") get defaultURLScheme.
+// It reports false for links with a scheme other than http(s), which aren't cleaned.
+func absoluteURL(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme == "" || u.Opaque != "" { // "example.com:8080/a" parses as scheme "example.com"
+		return defaultURLScheme + link, true
+	}
+	return link, strings.EqualFold(u.Scheme, "http") || strings.EqualFold(u.Scheme, "https")
+}
+
+// matchRulePrefix returns the first of rulePrefixes that name starts with
+func matchRulePrefix(name string, rulePrefixes []string) (string, bool) {
+	for _, rulePrefix := range rulePrefixes {
+		if strings.HasPrefix(name, rulePrefix) {
+			return rulePrefix, true
+		}
+	}
+	return "", false
+}
+
+// normalizeHost rewrites mobile hosts to their desktop counterparts according to HostRules.
+func normalizeHost(u *url.URL) (linkChange, bool) {
+	host := strings.ToLower(u.Hostname())
+	for mobileHost, desktopHost := range HostRules {
+		var normalized string
+		switch {
+		case host == mobileHost:
+			normalized = desktopHost
+		case strings.HasSuffix(host, "."+mobileHost): // Keep subdomains, e.g. the language in en.m.wikipedia.org
+			normalized = strings.TrimSuffix(host, mobileHost) + desktopHost
+		default:
+			continue
+		}
+		if port := u.Port(); port != "" {
+			normalized += ":" + port
+		}
+		change := linkChange{Kind: changeMobileHost, Rule: "HostRules[" + mobileHost + "]", From: u.Host, To: normalized}
+		u.Host = normalized
+		return change, true
+	}
+	return linkChange{}, false
+}
+
+// stripPathTrackers removes tracking path segments (PathRules) and matrix parameters (MatrixRules) from u.
+func stripPathTrackers(u *url.URL) (changes []linkChange) {
+	var pathDomains []string
+	for domainKey := range PathRules {
+		if strings.Contains(u.Host, domainKey) {
+			pathDomains = append(pathDomains, domainKey)
+		}
+	}
+	slices.Sort(pathDomains) // Stable rule IDs if several domain keys match
+
+	segments := strings.Split(u.EscapedPath(), "/")
+	kept := make([]string, 0, len(segments))
+	for i, segment := range segments {
+		if strings.Contains(segment, ";") { // Matrix parameters: /path;jsessionid=123;foo=bar
+			params := strings.Split(segment, ";")
+			keptParams := params[:1]
+			for _, param := range params[1:] {
+				key, value, _ := strings.Cut(param, "=")
+				// Matrix parameters are named, unlike query rules they must match the whole key
+				if idx := slices.IndexFunc(MatrixRules, func(name string) bool { return strings.EqualFold(name, key) }); idx >= 0 {
+					changes = append(changes, linkChange{Kind: changeMatrixParam, Rule: "MatrixRules:" + MatrixRules[idx], Key: key, Value: value})
+					continue
 				}
+				keptParams = append(keptParams, param)
 			}
-			sb.WriteString(processedWord)
-			if currentWordSanitized {
-				wasSanitized = true
+			segment = strings.Join(keptParams, ";")
+		}
+		if i > 0 && segment != "" { // Whole tracking segments: /dp/B00X/ref=sr_1_1
+			if rule, ok := matchPathRule(segment, pathDomains); ok {
+				changes = append(changes, linkChange{Kind: changePathSegment, Rule: rule, Key: segment})
+				continue
 			}
 		}
+		kept = append(kept, segment)
 	}
-
-	if scanErr := scanner.Err(); scanErr != nil {
-		return "", false, false, nil, nil, fmt.Errorf("error scanning input text: %w", scanErr)
+	if len(changes) == 0 {
+		return nil
 	}
 
-	// If it was marked as a TikTok photo album opportunity AND photos were actually downloaded,
-	// then it counts as "sanitized" (because an action is taken).
-	if isTikTokPhotoAlbum && len(downloadedPhotoPaths) > 0 {
-		wasSanitized = true
-	} else {
-		// If photo download failed, ensure isTikTokPhotoAlbum is false so it's not treated as an album.
-		isTikTokPhotoAlbum = false
+	escapedPath := strings.Join(kept, "/")
+	unescapedPath, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		log.Printf("Warning: Failed to unescape cleaned path '%s': %v. Keeping original path.", escapedPath, err)
+		return nil
 	}
+	u.Path = unescapedPath
+	u.RawPath = escapedPath
+	return changes
+}
 
-	return sb.String(), wasSanitized, isTikTokPhotoAlbum, downloadedPhotoPaths, originalURLs, nil
+func matchPathRule(segment string, domainKeys []string) (string, bool) {
+	for _, domainKey := range domainKeys {
+		if rulePrefix, ok := matchRulePrefix(segment, PathRules[domainKey]); ok {
+			return fmt.Sprintf("PathRules[%s]:%s", domainKey, rulePrefix), true
+		}
+	}
+	return "", false
 }
 
-func containsURL(text string) bool {
-	return strings.HasPrefix(text, "http://") || strings.HasPrefix(text, "https://")
+// stripFragmentTrackers removes text fragment directives and tracking key/value pairs (FragmentRules) from the fragment of u.
+func stripFragmentTrackers(u *url.URL) (changes []linkChange) {
+	fragment := u.EscapedFragment()
+	if fragment == "" {
+		return nil
+	}
+
+	if idx := strings.Index(fragment, textFragmentDirective); idx >= 0 {
+		changes = append(changes, linkChange{Kind: changeFragment, Rule: "text fragment", Key: fragment[idx:]})
+		fragment = fragment[:idx]
+	}
+	if strings.Contains(fragment, "=") { // Only treat the fragment as key/value pairs if it looks like one
+		pairs := strings.Split(fragment, "&")
+		kept := make([]string, 0, len(pairs))
+		for _, pair := range pairs {
+			key, _, hasValue := strings.Cut(pair, "=")
+			if rulePrefix, ok := matchRulePrefix(key, FragmentRules); hasValue && ok {
+				changes = append(changes, linkChange{Kind: changeFragment, Rule: "FragmentRules:" + rulePrefix, Key: pair})
+				continue
+			}
+			kept = append(kept, pair)
+		}
+		fragment = strings.Join(kept, "&")
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	unescapedFragment, err := url.PathUnescape(fragment)
+	if err != nil {
+		log.Printf("Warning: Failed to unescape cleaned fragment '%s': %v. Keeping original fragment.", fragment, err)
+		return nil
+	}
+	u.Fragment = unescapedFragment
+	u.RawFragment = fragment
+	return changes
 }
 
-func ExpandUrl(shortURL string) (string, error) {
+// ExpandUrl follows the redirects of shortURL. It returns the final URL and every URL visited on the way.
+func ExpandUrl(shortURL string) (string, []string, error) {
 	req, err := http.NewRequest("HEAD", shortURL, nil)
 	if err != nil {
-		return "", fmt.Errorf("failed to create HEAD request for %s: %w", shortURL, err)
+		return "", nil, fmt.Errorf("failed to create HEAD request for %s: %w", shortURL, err)
 	}
 	// req.Header.Set("User-Agent", "Mozilla/5.0...") // Optional: Set User-Agent if needed
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("HEAD request failed for %s: %w", shortURL, err)
+		return "", nil, fmt.Errorf("HEAD request failed for %s: %w", shortURL, err)
 	}
 	defer resp.Body.Close()
 
 	// We are interested in the final URL from resp.Request.URL after redirects.
 	// Default client follows redirects for HEAD.
 	if resp.StatusCode >= http.StatusBadRequest { // 400 and above are generally errors
-		return "", fmt.Errorf("received non-successful status code %d for %s", resp.StatusCode, shortURL)
+		return "", nil, fmt.Errorf("received non-successful status code %d for %s", resp.StatusCode, shortURL)
 	}
-	return resp.Request.URL.String(), nil
-}
 
-func escapeMarkdown(text string) string {
-	return markdownEscaper.Replace(text)
+	var redirects []string
+	for r := resp.Request; r != nil; { // Each request after a redirect links back to the response that caused it
+		redirects = append([]string{r.URL.String()}, redirects...)
+		if r.Response == nil {
+			break
+		}
+		r = r.Response.Request
+	}
+	return resp.Request.URL.String(), redirects, nil
 }
 
 func downloadImage(imageURL string) (string, error) {
@@ -599,7 +974,7 @@ func fetchTikTokPhotos(photoPostURL string) ([]string, error) {
 	return successfulPaths, nil
 }
 
-func createURLButtons(urls []string) [][]tele.InlineButton {
+func createURLButtons(urls []string, lang string) [][]tele.InlineButton {
 	if len(urls) == 0 {
 		return nil
 	}
@@ -613,7 +988,7 @@ func createURLButtons(urls []string) [][]tele.InlineButton {
 			continue
 		}
 		btn := tele.InlineButton{
-			Text: fmt.Sprintf("Original Link #%d", i+1),
+			Text: tr(lang, "button.original_link", i+1),
 			URL:  u,
 		}
 		rows = append(rows, []tele.InlineButton{btn})
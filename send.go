@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// replyParameters mirrors the Bot API's ReplyParameters. telebot v4.0.0-beta.4 never sends
+// SendOptions.ReplyParams, so reposts that need them go through Bot.Raw. If Telegram rejects
+// the reply, the repost is sent again without it rather than leaving the original in place.
+type replyParameters struct {
+	MessageID         int                  `json:"message_id"`
+	ChatID            int64                `json:"chat_id,omitempty"` // Only for replies to another chat
+	AllowWithoutReply bool                 `json:"allow_sending_without_reply,omitempty"`
+	Quote             string               `json:"quote,omitempty"`
+	QuoteEntities     []tele.MessageEntity `json:"quote_entities,omitempty"`
+	QuotePosition     int                  `json:"quote_position,omitempty"`
+}
+
+// repostReply returns the reply parameters needed to keep a partial quote or a reply to another
+// chat. It returns nil when a plain SendOptions.ReplyTo is enough.
+func repostReply(m *tele.Message) *replyParameters {
+	var reply *replyParameters
+	switch {
+	case m.ExternalReply != nil && m.ExternalReply.Chat != nil && m.ExternalReply.MessageID != 0:
+		// The bot usually isn't in the other chat, the repost must not fail because of that
+		reply = &replyParameters{ChatID: m.ExternalReply.Chat.ID, MessageID: m.ExternalReply.MessageID, AllowWithoutReply: true}
+	case m.Quote != nil && m.ReplyTo != nil:
+		reply = &replyParameters{MessageID: m.ReplyTo.ID, AllowWithoutReply: true}
+	default:
+		return nil
+	}
+	if m.Quote != nil {
+		reply.Quote = m.Quote.Text
+		reply.QuoteEntities = m.Quote.Entities
+		reply.QuotePosition = m.Quote.Position
+	}
+	return reply
+}
+
+func sendText(b *tele.Bot, chat *tele.Chat, text string, opts *tele.SendOptions, reply *replyParameters) (*tele.Message, error) {
+	if reply == nil {
+		return b.Send(chat, text, opts)
+	}
+	payload := map[string]any{
+		"chat_id":          chat.ID,
+		"text":             text,
+		"reply_parameters": reply,
+	}
+	embedRawOptions(payload, opts, "entities")
+	msg, err := rawMessage(b, "sendMessage", payload)
+	if err != nil {
+		logReplyFallback("sendMessage", err)
+		return b.Send(chat, text, opts)
+	}
+	return msg, nil
+}
+
+// logReplyFallback notes that a repost is sent again without its reply parameters
+func logReplyFallback(method string, err error) {
+	log.Printf("%s with reply parameters failed, retrying without: %v", method, err)
+}
+
+// sendMediaRepost sends media (see mediaWithCaption) as a copy of the original message m
+func sendMediaRepost(b *tele.Bot, m *tele.Message, media tele.Inputtable, opts *tele.SendOptions, reply *replyParameters) (*tele.Message, error) {
+	if reply == nil {
+		return b.Send(m.Chat, media, opts)
+	}
+	inputMedia := media.InputMedia()
+	payload := map[string]any{
+		"chat_id":                  m.Chat.ID,
+		"from_chat_id":             m.Chat.ID,
+		"message_id":               m.ID,
+		"caption":                  inputMedia.Caption,
+		"show_caption_above_media": inputMedia.CaptionAbove,
+		"reply_parameters":         reply,
+	}
+	embedRawOptions(payload, opts, "caption_entities")
+
+	var resp struct {
+		Result struct {
+			MessageID int `json:"message_id"`
+		}
+	}
+	data, err := b.Raw("copyMessage", payload)
+	if err != nil {
+		logReplyFallback("copyMessage", err)
+		return b.Send(m.Chat, media, opts)
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode copyMessage response: %w", err)
+	}
+	return &tele.Message{ID: resp.Result.MessageID, Chat: m.Chat}, nil
+}
+
+// sendMediaByFileID sends media (see mediaWithCaption) again by its file ID. Unlike sendMediaRepost
+// it doesn't need the original message, which is gone when an original is restored.
+func sendMediaByFileID(b *tele.Bot, chat *tele.Chat, media tele.Inputtable, opts *tele.SendOptions, reply *replyParameters) (*tele.Message, error) {
+	if reply == nil {
+		return b.Send(chat, media, opts)
+	}
+	inputMedia := media.InputMedia()
+	payload := map[string]any{
+		"chat_id":                  chat.ID,
+		inputMedia.Type:            media.MediaFile().FileID,
+		"caption":                  inputMedia.Caption,
+		"show_caption_above_media": inputMedia.CaptionAbove,
+		"has_spoiler":              inputMedia.HasSpoiler,
+		"reply_parameters":         reply,
+	}
+	embedRawOptions(payload, opts, "caption_entities")
+	method := "send" + strings.ToUpper(inputMedia.Type[:1]) + inputMedia.Type[1:] // e.g. "sendPhoto"
+	msg, err := rawMessage(b, method, payload)
+	if err != nil {
+		logReplyFallback(method, err)
+		return b.Send(chat, media, opts)
+	}
+	return msg, nil
+}
+
+// sendAlbumRepost sends an album of already uploaded media (file IDs only)
+func sendAlbumRepost(b *tele.Bot, chat *tele.Chat, album tele.Album, opts *tele.SendOptions, reply *replyParameters) ([]tele.Message, error) {
+	if reply == nil {
+		return b.SendAlbum(chat, album, opts)
+	}
+	media := make([]tele.InputMedia, 0, len(album))
+	for _, item := range album {
+		inputMedia := item.InputMedia()
+		inputMedia.Media = item.MediaFile().FileID
+		media = append(media, inputMedia)
+	}
+	payload := map[string]any{
+		"chat_id":          chat.ID,
+		"media":            media,
+		"reply_parameters": reply,
+	}
+	embedRawOptions(payload, &tele.SendOptions{ThreadID: opts.ThreadID, DisableNotification: opts.DisableNotification}, "")
+
+	data, err := b.Raw("sendMediaGroup", payload)
+	if err != nil {
+		logReplyFallback("sendMediaGroup", err)
+		return b.SendAlbum(chat, album, opts)
+	}
+	var resp struct {
+		Result []tele.Message
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode sendMediaGroup response: %w", err)
+	}
+	return resp.Result, nil
+}
+
+// embedRawOptions is the Bot.Raw counterpart of telebot's handling of SendOptions
+func embedRawOptions(payload map[string]any, opts *tele.SendOptions, entitiesKey string) {
+	if opts == nil {
+		return
+	}
+	if opts.ThreadID != 0 {
+		payload["message_thread_id"] = opts.ThreadID
+	}
+	if len(opts.Entities) > 0 && entitiesKey != "" {
+		payload[entitiesKey] = opts.Entities
+	}
+	if opts.ReplyMarkup != nil {
+		markup := *opts.ReplyMarkup
+		markup.InlineKeyboard = encodeCallbackData(markup.InlineKeyboard)
+		payload["reply_markup"] = &markup
+	}
+	if opts.DisableNotification {
+		payload["disable_notification"] = true
+	}
+	if opts.Protected {
+		payload["protect_content"] = true
+	}
+}
+
+func rawMessage(b *tele.Bot, method string, payload map[string]any) (*tele.Message, error) {
+	data, err := b.Raw(method, payload)
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Result *tele.Message
+	}
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to decode %s response: %w", method, err)
+	}
+	return resp.Result, nil
+}
+
+// appendControlButton adds a callback button (Undo, 🗑) to the last row of a repost's keyboard if it
+// holds control buttons already, so they share one row below the "Original Link" buttons.
+func appendControlButton(sendOpts *tele.SendOptions, button tele.InlineButton) {
+	if sendOpts.ReplyMarkup == nil {
+		sendOpts.ReplyMarkup = &tele.ReplyMarkup{}
+	}
+	keyboard := sendOpts.ReplyMarkup.InlineKeyboard
+	if last := len(keyboard) - 1; last >= 0 && keyboard[last][0].Unique != "" {
+		keyboard[last] = append(keyboard[last], button)
+		return
+	}
+	sendOpts.ReplyMarkup.InlineKeyboard = append(keyboard, []tele.InlineButton{button})
+}
+
+// encodeCallbackData returns a copy of keyboard with the callback data of Unique buttons in telebot's
+// "\f<unique>|<data>" format, which Bot.Send applies on its own but Bot.Raw doesn't.
+func encodeCallbackData(keyboard [][]tele.InlineButton) [][]tele.InlineButton {
+	encoded := make([][]tele.InlineButton, len(keyboard))
+	for i, row := range keyboard {
+		encoded[i] = slices.Clone(row)
+		for j := range encoded[i] {
+			button := &encoded[i][j]
+			if button.Unique == "" {
+				continue
+			}
+			button.Data = "\f" + button.Unique + "|" + button.Data
+			button.Unique = ""
+		}
+	}
+	return encoded
+}
+
+// sendSuggestion replies to m with its cleaned text and leaves m in place
+func sendSuggestion(b *tele.Bot, m *tele.Message, result sanitizeResult) error {
+	removeCachedImages(result.PhotoPaths) // Suggestions are text only, TikTok albums are only sent as reposts
+	if !slices.ContainsFunc(result.Links, func(link linkReport) bool { return link.Cleaned != link.Original }) {
+		return nil // Nothing but a TikTok album to offer
+	}
+
+	sendOpts := &tele.SendOptions{ReplyTo: m, Entities: result.Entities}
+	if m.TopicMessage {
+		sendOpts.ThreadID = m.ThreadID
+	}
+	if _, err := b.Send(m.Chat, result.Text, sendOpts); err != nil {
+		log.Printf("Failed to send cleaned link suggestion to chat %d: %v", m.Chat.ID, err)
+		return err
+	}
+	markHandled(m)
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// ChatSettings holds the per-chat behavior that chat admins can change
+type ChatSettings struct {
+	DeleteOriginal      bool   `json:"delete_original"`       // Delete the user's message after reposting it
+	OriginalLinkButtons bool   `json:"original_link_buttons"` // Attach "Original Link" buttons to reposts
+	FrontendTikTok      bool   `json:"frontend_tiktok"`       // Rewrite TikTok videos to tiktokCleanHost
+	FrontendX           bool   `json:"frontend_x"`            // Rewrite x.com to fixupXHost
+	FrontendInstagram   bool   `json:"frontend_instagram"`    // Rewrite Instagram reels and posts to ddInstagramHost
+	TikTokAlbums        bool   `json:"tiktok_albums"`         // Download TikTok photo posts and repost them as an album
+	AnonMarker          string `json:"anon_marker"`           // Keyword that drops the attribution, empty to disable
+	NoCutMarker         string `json:"nocut_marker"`          // Keyword that leaves a message alone, empty to disable
+	SuggestOnly         bool   `json:"suggest_only"`          // Reply with cleaned links instead of reposting
+	ProcessEdits        bool   `json:"process_edits"`         // Sanitize messages again when they are edited
+	AttributionTemplate string `json:"attribution_template"`  // Prefix naming the sender of a repost, empty for none
+	Language            string `json:"language"`              // Language of bot messages, empty to follow each sender's Telegram app
+}
+
+// defaultChatSettings apply to every chat that hasn't changed a setting
+var defaultChatSettings = ChatSettings{
+	DeleteOriginal:      true,
+	OriginalLinkButtons: true,
+	FrontendTikTok:      true,
+	FrontendX:           true,
+	FrontendInstagram:   true,
+	TikTokAlbums:        true,
+	AnonMarker:          msgMarkerAnon,
+	NoCutMarker:         msgMarkerNoCut,
+	ProcessEdits:        true,
+	AttributionTemplate: defaultAttributionTemplate,
+}
+
+// chatSettingsStore holds the settings of chats that changed the defaults, opened in main
+var chatSettingsStore settingsStore
+
+// settingsUpdateMu serializes read-modify-write cycles of updateSettings
+var settingsUpdateMu sync.Mutex
+
+func settingsFor(chatID int64) ChatSettings {
+	settings, found, err := chatSettingsStore.Load(chatID)
+	if err != nil {
+		log.Printf("Warning: Failed to load settings of chat %d: %v. Using defaults.", chatID, err)
+	}
+	if !found || err != nil {
+		return defaultChatSettings
+	}
+	return settings
+}
+
+func updateSettings(chatID int64, update func(*ChatSettings)) error {
+	settingsUpdateMu.Lock()
+	defer settingsUpdateMu.Unlock()
+	settings := settingsFor(chatID)
+	update(&settings)
+	if err := chatSettingsStore.Save(chatID, settings); err != nil {
+		log.Printf("Failed to save settings of chat %d: %v", chatID, err)
+		return err
+	}
+	return nil
+}
+
+// hasMarker reports whether text contains a marker keyword, disabled (empty) markers never match
+func hasMarker(text, marker string) bool {
+	return marker != "" && strings.Contains(text, marker)
+}
+
+func handleMigration(c tele.Context) error {
+	from, to := c.Migration()
+	if err := chatSettingsStore.Move(from, to); err != nil {
+		log.Printf("Failed to move settings of chat %d to supergroup %d: %v", from, to, err)
+		return err
+	}
+	return nil
+}
+
+// isChatAdmin reports whether the sender of m may change the chat's settings.
+// Everyone is admin of their private chat, anonymous admins post as the group itself.
+func isChatAdmin(b *tele.Bot, m *tele.Message) bool {
+	if m.Private() || (m.SenderChat != nil && m.SenderChat.ID == m.Chat.ID) {
+		return true
+	}
+	if m.Sender == nil {
+		return false
+	}
+	return isChatAdminUser(b, m.Chat, m.Sender)
+}
+
+func isChatAdminUser(b *tele.Bot, chat *tele.Chat, user *tele.User) bool {
+	member, err := b.ChatMemberOf(chat, user)
+	if err != nil {
+		log.Printf("Failed to look up chat member %d in chat %d: %v", user.ID, chat.ID, err)
+		return false
+	}
+	return member.Role == tele.Creator || member.Role == tele.Administrator
+}
+
+func handleEditsCommand(c tele.Context, b *tele.Bot) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	if !isChatAdmin(b, c.Message()) {
+		return c.Reply(tr(lang, "command.admin_only"))
+	}
+
+	switch strings.ToLower(strings.TrimSpace(c.Message().Payload)) {
+	case "on":
+		if err := updateSettings(c.Chat().ID, func(s *ChatSettings) { s.ProcessEdits = true }); err != nil {
+			return c.Reply(tr(lang, "command.save_failed"))
+		}
+		return c.Reply(tr(lang, "edits.enabled"))
+	case "off":
+		if err := updateSettings(c.Chat().ID, func(s *ChatSettings) { s.ProcessEdits = false }); err != nil {
+			return c.Reply(tr(lang, "command.save_failed"))
+		}
+		return c.Reply(tr(lang, "edits.disabled"))
+	default:
+		state := tr(lang, "command.state_off")
+		if settingsFor(c.Chat().ID).ProcessEdits {
+			state = tr(lang, "command.state_on")
+		}
+		return c.Reply(tr(lang, "edits.status", state))
+	}
+}
+
+func handleAttributionCommand(c tele.Context, b *tele.Bot) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	if !isChatAdmin(b, c.Message()) {
+		return c.Reply(tr(lang, "command.admin_only"))
+	}
+
+	template := strings.TrimSpace(c.Message().Payload)
+	switch strings.ToLower(template) {
+	case "":
+		return c.Reply(tr(lang, "attribution.status", describeAttribution(settingsFor(c.Chat().ID).AttributionTemplate, lang)))
+	case "none":
+		template = ""
+	case "default":
+		template = defaultAttributionTemplate
+	default:
+		if utf8.RuneCountInString(template) > maxAttributionTemplateLength {
+			return c.Reply(tr(lang, "attribution.too_long", maxAttributionTemplateLength))
+		}
+	}
+
+	if err := updateSettings(c.Chat().ID, func(s *ChatSettings) { s.AttributionTemplate = template }); err != nil {
+		return c.Reply(tr(lang, "command.save_failed"))
+	}
+	if template == "" {
+		return c.Reply(tr(lang, "attribution.removed"))
+	}
+	return c.Reply(tr(lang, "attribution.changed", describeAttribution(template, lang)))
+}
+
+func describeAttribution(template, lang string) string {
+	switch template {
+	case "":
+		return tr(lang, "attribution.none")
+	case defaultAttributionTemplate:
+		return tr(lang, "attribution.default")
+	}
+	return template
+}
+
+func handleLanguageCommand(c tele.Context, b *tele.Bot) error {
+	lang := languageFor(c.Chat().ID, c.Sender())
+	if !isChatAdmin(b, c.Message()) {
+		return c.Reply(tr(lang, "command.admin_only"))
+	}
+
+	choice := strings.ToLower(strings.TrimSpace(c.Message().Payload))
+	switch {
+	case choice == "":
+		current := tr(lang, "language.auto")
+		if chosen := settingsFor(c.Chat().ID).Language; chosen != "" {
+			current = tr(chosen, "language.name")
+		}
+		return c.Reply(tr(lang, "language.status", current, strings.Join(supportedLanguages(), "|")))
+	case choice == "auto":
+		choice = ""
+	case !isSupportedLanguage(choice):
+		return c.Reply(tr(lang, "language.unsupported", strings.Join(supportedLanguages(), ", ")))
+	}
+
+	if err := updateSettings(c.Chat().ID, func(s *ChatSettings) { s.Language = choice }); err != nil {
+		return c.Reply(tr(lang, "command.save_failed"))
+	}
+	lang = languageFor(c.Chat().ID, c.Sender())
+	if choice == "" {
+		return c.Reply(tr(lang, "language.changed", tr(lang, "language.auto")))
+	}
+	return c.Reply(tr(lang, "language.changed", tr(lang, "language.name")))
+}
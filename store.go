@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// settingsStore persists ChatSettings keyed by chat ID
+type settingsStore interface {
+	// Load returns the settings of a chat, found is false for chats that never changed the defaults
+	Load(chatID int64) (settings ChatSettings, found bool, err error)
+	Save(chatID int64, settings ChatSettings) error
+	// Move carries the settings of a group over to the supergroup it was upgraded to
+	Move(fromChatID, toChatID int64) error
+}
+
+// settingsSchemaVersion is the version of ChatSettings written to new settings files
+const settingsSchemaVersion = 1
+
+// settingsMigrations upgrade the stored settings of a chat by one schema version each,
+// settingsMigrations[i] turns version i+1 into version i+2. Newly added fields need no
+// migration, chats that never stored them get the value from defaultChatSettings.
+var settingsMigrations []func(chat map[string]any)
+
+// settingsFile is the on-disk layout of jsonSettingsStore
+type settingsFile struct {
+	Version int                        `json:"version"`
+	Chats   map[string]json.RawMessage `json:"chats"` // Keyed by chat ID
+}
+
+// jsonSettingsStore keeps all chat settings in memory and writes them to a single JSON file on every change
+type jsonSettingsStore struct {
+	mu    sync.Mutex
+	path  string
+	chats map[int64]ChatSettings
+}
+
+func openJSONSettingsStore(path string) (*jsonSettingsStore, error) {
+	store := &jsonSettingsStore{path: path, chats: make(map[int64]ChatSettings)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil // Nothing stored yet, every chat uses the defaults
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read settings file %s: %w", path, err)
+	}
+
+	var file settingsFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse settings file %s: %w", path, err)
+	}
+	if file.Version > settingsSchemaVersion {
+		return nil, fmt.Errorf("settings file %s has schema version %d, this build supports up to %d", path, file.Version, settingsSchemaVersion)
+	}
+
+	for key, raw := range file.Chats {
+		chatID, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			log.Printf("Warning: Skipping settings with invalid chat ID %q in %s", key, path)
+			continue
+		}
+		if file.Version < settingsSchemaVersion {
+			if raw, err = migrateChatSettings(raw, file.Version); err != nil {
+				return nil, fmt.Errorf("failed to migrate settings of chat %d: %w", chatID, err)
+			}
+		}
+		settings := defaultChatSettings // Fields missing from the file keep their default
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, fmt.Errorf("failed to parse settings of chat %d: %w", chatID, err)
+		}
+		store.chats[chatID] = settings
+	}
+
+	if file.Version < settingsSchemaVersion {
+		log.Printf("Migrated settings file %s from schema version %d to %d", path, file.Version, settingsSchemaVersion)
+		if err := store.write(); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+func migrateChatSettings(raw json.RawMessage, version int) (json.RawMessage, error) {
+	var chat map[string]any
+	if err := json.Unmarshal(raw, &chat); err != nil {
+		return nil, err
+	}
+	for v := max(version, 1); v < settingsSchemaVersion; v++ {
+		settingsMigrations[v-1](chat)
+	}
+	return json.Marshal(chat)
+}
+
+func (s *jsonSettingsStore) Load(chatID int64) (ChatSettings, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, found := s.chats[chatID]
+	return settings, found, nil
+}
+
+func (s *jsonSettingsStore) Save(chatID int64, settings ChatSettings) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	previous, existed := s.chats[chatID]
+	s.chats[chatID] = settings
+	if err := s.write(); err != nil { // Keep the settings in effect matching the file
+		if existed {
+			s.chats[chatID] = previous
+		} else {
+			delete(s.chats, chatID)
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *jsonSettingsStore) Move(fromChatID, toChatID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	settings, found := s.chats[fromChatID]
+	if !found {
+		return nil
+	}
+	previous, existed := s.chats[toChatID]
+	s.chats[toChatID] = settings
+	delete(s.chats, fromChatID)
+	if err := s.write(); err != nil { // Keep the settings in effect matching the file
+		s.chats[fromChatID] = settings
+		if existed {
+			s.chats[toChatID] = previous
+		} else {
+			delete(s.chats, toChatID)
+		}
+		return err
+	}
+	return nil
+}
+
+// write replaces the settings file with the current settings of all chats
+func (s *jsonSettingsStore) write() error {
+	file := settingsFile{Version: settingsSchemaVersion, Chats: make(map[string]json.RawMessage, len(s.chats))}
+	for chatID, settings := range s.chats {
+		raw, err := json.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("failed to encode settings of chat %d: %w", chatID, err)
+		}
+		file.Chats[strconv.FormatInt(chatID, 10)] = raw
+	}
+	return writeJSONFile(s.path, file)
+}
+
+// writeJSONFile replaces the file at path atomically, so a crash never leaves a truncated file behind
+func writeJSONFile(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name()) // No-op once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// dropExpired removes the entries of m that are older than ttl, at returns when an entry was added.
+// Maps of short-lived entries (undo tokens, handled messages, repost owners) call it on every write,
+// so neither they nor the files they are saved to grow forever.
+func dropExpired[K comparable, V any](m map[K]V, ttl time.Duration, at func(V) time.Time) {
+	maps.DeleteFunc(m, func(_ K, v V) bool { return time.Since(at(v)) > ttl })
+}
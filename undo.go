@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"sync"
+	"time"
+
+	tele "gopkg.in/telebot.v4"
+)
+
+// undoCallbackUnique identifies "Undo" buttons, their data is the token of a pendingUndo
+const undoCallbackUnique = "undo"
+
+// undoTTL is how long the original of a repost can be restored
+const undoTTL = 24 * time.Hour
+
+// pendingUndo keeps what is needed to restore a deleted original. Callback data is limited to
+// 64 bytes, so the buttons only carry a token pointing here.
+type pendingUndo struct {
+	original  *tele.Message
+	createdAt time.Time
+}
+
+var pendingUndos = struct {
+	sync.Mutex
+	byToken map[string]pendingUndo
+}{byToken: make(map[string]pendingUndo)}
+
+// offerUndo adds an "Undo" button to the repost of m that restores m as it was sent
+func offerUndo(sendOpts *tele.SendOptions, m *tele.Message) {
+	tokenBytes := make([]byte, 8)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		log.Printf("Warning: Failed to create undo token: %v. Reposting without undo button.", err)
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	pendingUndos.Lock()
+	dropExpired(pendingUndos.byToken, undoTTL, func(undo pendingUndo) time.Time { return undo.createdAt })
+	pendingUndos.byToken[token] = pendingUndo{original: m, createdAt: time.Now()}
+	pendingUndos.Unlock()
+
+	appendControlButton(sendOpts, tele.InlineButton{Unique: undoCallbackUnique, Text: tr(languageFor(m.Chat.ID, m.Sender), "button.undo"), Data: token})
+}
+
+// handleUndoCallback reposts the original message unchanged and deletes the cleaned repost.
+// Only the original sender and chat admins may undo.
+func handleUndoCallback(c tele.Context, b *tele.Bot) error {
+	repost := c.Message()
+	if repost == nil { // The repost is too old to be accessible
+		return c.Respond()
+	}
+	lang := languageFor(repost.Chat.ID, c.Sender())
+	token := c.Callback().Data
+
+	pendingUndos.Lock()
+	undo, ok := pendingUndos.byToken[token]
+	pendingUndos.Unlock()
+	if !ok || time.Since(undo.createdAt) > undoTTL {
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "undo.expired"), ShowAlert: true})
+	}
+
+	original := undo.original
+	isSender := original.SenderChat == nil && original.Sender != nil && c.Sender().ID == original.Sender.ID
+	if !isSender && !isChatAdminUser(b, original.Chat, c.Sender()) {
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "undo.not_allowed"), ShowAlert: true})
+	}
+
+	pendingUndos.Lock()
+	_, stillPending := pendingUndos.byToken[token]
+	delete(pendingUndos.byToken, token)
+	pendingUndos.Unlock()
+	if !stillPending {
+		return c.Respond() // Someone else pressed the button first
+	}
+
+	text, entities := original.Text, original.Entities
+	if original.Media() != nil {
+		text, entities = original.Caption, original.CaptionEntities
+	}
+	restoredText, restoredEntities := attributeMessage(original, sanitizeResult{Text: text, Entities: entities})
+	sendOpts := repostOptions(original, sanitizeResult{})
+	sendOpts.Entities = restoredEntities
+	var err error
+	if original.Media() != nil {
+		sendOpts.HasSpoiler = original.HasMediaSpoiler
+		_, err = sendMediaByFileID(b, original.Chat, mediaWithCaption(original, restoredText), sendOpts, repostReply(original)) // original is deleted, it can't be copied
+	} else {
+		_, err = sendText(b, original.Chat, restoredText, sendOpts, repostReply(original))
+	}
+	if err != nil {
+		log.Printf("Failed to restore original message (ID: %d, ChatID: %d): %v", original.ID, original.Chat.ID, err)
+		return c.Respond(&tele.CallbackResponse{Text: tr(lang, "undo.failed"), ShowAlert: true})
+	}
+
+	if err := b.Delete(repost); err != nil {
+		log.Printf("Failed to delete repost (ID: %d, ChatID: %d): %v", repost.ID, repost.Chat.ID, err)
+	}
+	return c.Respond()
+}